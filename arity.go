@@ -0,0 +1,168 @@
+package config
+
+import "strings"
+
+// Arity is the arguments a directive takes, like the flags of ngx_command_t,
+// it can be combined, e.g. Take12|TakeBlock
+type Arity uint
+
+const (
+	NoArgs Arity = 1 << iota
+	Take1
+	Take2
+	Take3
+	Take4
+	Take5
+	Take6
+	Take7
+	// Flag takes one of on, off, yes, no, true and false
+	Flag
+	OneOrMore
+	TwoOrMore
+	AnyArgs
+	// TakeBlock requires a block, without it a block is not allowed
+	TakeBlock
+
+	Take12   = Take1 | Take2
+	Take13   = Take1 | Take3
+	Take23   = Take2 | Take3
+	Take123  = Take1 | Take2 | Take3
+	Take1234 = Take1 | Take2 | Take3 | Take4
+)
+
+var arityNames = []string{
+	"NoArgs", "Take1", "Take2", "Take3", "Take4", "Take5", "Take6", "Take7",
+	"Flag", "OneOrMore", "TwoOrMore", "AnyArgs", "TakeBlock",
+}
+
+func (a Arity) String() string {
+	var names []string
+	for i, name := range arityNames {
+		if a&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "Unchecked"
+	}
+	return strings.Join(names, "|")
+}
+
+// DirectiveOption restricts where a directive is allowed and the arguments it
+// takes, it's given to Directive, HandleFunc and HandleBlock
+type DirectiveOption interface {
+	applyDirective(c *Configurable)
+}
+
+func (a Arity) applyDirective(c *Configurable) {
+	c.arity |= a
+}
+
+type contexts []string
+
+func (cs contexts) applyDirective(c *Configurable) {
+	c.contexts = append(c.contexts, cs...)
+}
+
+// Contexts allows a directive in the named contexts: "main" is the top level,
+// "server" is any block named server, "http.server" is a server block in an
+// http block, and "*" is anywhere
+func Contexts(names ...string) DirectiveOption {
+	return contexts(names)
+}
+
+// allowed reports whether the directive is allowed in the blocks of path
+func (c *Configurable) allowed(path []string) bool {
+	if len(c.contexts) == 0 {
+		return true
+	}
+
+	for _, ctx := range c.contexts {
+		if ctx == "*" || (ctx == "main" && len(path) == 0) {
+			return true
+		}
+
+		names := strings.Split(ctx, ".")
+		if ctx == "main" || len(names) > len(path) {
+			continue
+		}
+
+		match := true
+		for i, name := range names {
+			if path[len(path)-len(names)+i] != name {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkArity checks the arguments of the directive name against the arity of c
+func (p *parser) checkArity(c *Configurable, name string, args []string, block bool) error {
+	a := c.arity
+	if a == 0 {
+		return nil
+	}
+
+	if a&TakeBlock != 0 && !block {
+		return p.error("directive \"%s\" has no opening \"{\"", name)
+	}
+	if a&TakeBlock == 0 && block {
+		return p.error("directive \"%s\" is not terminated by \";\"", name)
+	}
+
+	n := len(args)
+	var ok bool
+	switch {
+	case a&^TakeBlock == 0, a&AnyArgs != 0:
+		ok = true
+	case a&OneOrMore != 0 && n >= 1, a&TwoOrMore != 0 && n >= 2:
+		ok = true
+	case a&Flag != 0 && n == 1:
+		if !isFlag(args[0]) {
+			return p.error("invalid value \"%s\" in \"%s\" directive, it must be \"on\", \"off\", \"yes\", \"no\", \"true\" or \"false\"", args[0], name)
+		}
+		ok = true
+	case n == 0:
+		ok = a&NoArgs != 0
+	case n <= 7:
+		ok = a&(Take1<<(n-1)) != 0
+	}
+
+	if !ok {
+		return p.error("invalid number of arguments in \"%s\" directive", name)
+	}
+
+	return nil
+}
+
+// checkCommand checks the arguments of the directive just started by
+// fetchDirective, s is its value or the label of its block
+func (p *parser) checkCommand(s string, block bool) error {
+	c := p.command
+	if c == nil {
+		return nil
+	}
+	p.command = nil
+
+	args, err := p.splitQuoted(s)
+	if err != nil {
+		return err
+	}
+	return p.checkArity(c, c.name, args, block)
+}
+
+// isFlag reports whether s is a value of Flag, other spellings taken by
+// strconv.ParseBool like 1 or TRUE are not
+func isFlag(s string) bool {
+	switch s {
+	case "on", "off", "yes", "no", "true", "false":
+		return true
+	}
+	return false
+}
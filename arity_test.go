@@ -0,0 +1,147 @@
+package config
+
+import "testing"
+
+type arityConf struct {
+	Name string
+	Http struct {
+		Server []struct {
+			Name string
+		}
+	}
+	Server []struct {
+		Name string
+	}
+}
+
+// arityUnmarshal decodes src, setup registers directives with handlers doing nothing
+func arityUnmarshal(t *testing.T, src string, setup func(cfg *Config, handle HandlerFunc, block BlockHandlerFunc)) error {
+	t.Helper()
+
+	handle := func(ctx *DirectiveContext, args []string) error { return nil }
+	block := func(ctx *DirectiveContext, args []string, b *Block) error { return nil }
+	return unmarshalString(t, src, &arityConf{}, func(cfg *Config) {
+		setup(cfg, handle, block)
+	})
+}
+
+func TestContexts(t *testing.T) {
+	tests := []struct {
+		name     string
+		contexts []string
+		src      string
+		err      string
+	}{
+		{name: "anywhere by default", src: "listen 1; http { listen 1; server { listen 1; } }"},
+		{name: "main", contexts: []string{"main"}, src: "listen 1;"},
+		{name: "main in a block", contexts: []string{"main"}, src: "server { listen 1; }", err: "\"listen\" directive is not allowed here in test.conf:1"},
+		{name: "block name", contexts: []string{"server"}, src: "server { listen 1; } http { server { listen 1; } }"},
+		{name: "block name at top level", contexts: []string{"server"}, src: "listen 1;", err: "is not allowed here"},
+		{name: "block name in another block", contexts: []string{"server"}, src: "http { listen 1; }", err: "is not allowed here"},
+		{name: "path", contexts: []string{"http.server"}, src: "http { server { listen 1; } }"},
+		{name: "path out of its parent", contexts: []string{"http.server"}, src: "server { listen 1; }", err: "is not allowed here"},
+		{name: "any of", contexts: []string{"main", "http"}, src: "listen 1; http { listen 1; }"},
+		{name: "any of in another block", contexts: []string{"main", "http"}, src: "http { server { listen 1; } }", err: "is not allowed here"},
+		{name: "star", contexts: []string{"*"}, src: "listen 1; http { server { listen 1; } }"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := arityUnmarshal(t, tt.src, func(cfg *Config, handle HandlerFunc, block BlockHandlerFunc) {
+				var opts []DirectiveOption
+				if tt.contexts != nil {
+					opts = append(opts, Contexts(tt.contexts...))
+				}
+				_, err := cfg.HandleFunc("listen", handle, opts...)
+				wantError(t, err, "")
+			})
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestDirectiveContexts(t *testing.T) {
+	// a Directive is only allowed at the top level by default
+	var level string
+	err := arityUnmarshal(t, "level a; server { level b; }", func(cfg *Config, handle HandlerFunc, block BlockHandlerFunc) {
+		_, err := cfg.Directive("level", &level)
+		wantError(t, err, "")
+	})
+	wantError(t, err, "\"level\" directive is not allowed here")
+
+	err = arityUnmarshal(t, "level a; server { level b; }", func(cfg *Config, handle HandlerFunc, block BlockHandlerFunc) {
+		_, err := cfg.Directive("level", &level, Contexts("main", "server"))
+		wantError(t, err, "")
+	})
+	wantError(t, err, "")
+	if level != "b" {
+		t.Fatalf("level = %q, want b", level)
+	}
+}
+
+func TestArity(t *testing.T) {
+	tests := []struct {
+		name  string
+		arity Arity
+		block bool
+		src   string
+		err   string
+	}{
+		{name: "unchecked", src: "d; d a b c d e f g h;"},
+		{name: "no args", arity: NoArgs, src: "d;"},
+		{name: "no args with one", arity: NoArgs, src: "d a;", err: "invalid number of arguments in \"d\" directive"},
+		{name: "take1", arity: Take1, src: "d a;"},
+		{name: "take1 without", arity: Take1, src: "d;", err: "invalid number of arguments in \"d\" directive"},
+		{name: "take1 with two", arity: Take1, src: "d a b;", err: "invalid number of arguments"},
+		{name: "take12", arity: Take12, src: "d a; d a b;"},
+		{name: "take12 with three", arity: Take12, src: "d a b c;", err: "invalid number of arguments"},
+		{name: "take7", arity: Take7, src: "d a b c d e f g;"},
+		{name: "take13 with two", arity: Take13, src: "d a; d a b c; d a b;", err: "invalid number of arguments in \"d\" directive in test.conf:1"},
+		{name: "one or more", arity: OneOrMore, src: "d a; d a b c d e f g h;"},
+		{name: "one or more without", arity: OneOrMore, src: "d;", err: "invalid number of arguments"},
+		{name: "two or more", arity: TwoOrMore, src: "d a b c d e f g h;"},
+		{name: "two or more with one", arity: TwoOrMore, src: "d a;", err: "invalid number of arguments"},
+		{name: "any args", arity: AnyArgs, src: "d; d a b c d e f g h;"},
+		{name: "flag", arity: Flag, src: "d on; d off; d yes; d no; d true; d false;"},
+		{name: "flag without", arity: Flag, src: "d;", err: "invalid number of arguments"},
+		{name: "flag with two", arity: Flag, src: "d on off;", err: "invalid number of arguments"},
+		{name: "flag number", arity: Flag, src: "d 1;", err: "invalid value \"1\" in \"d\" directive, it must be \"on\", \"off\", \"yes\", \"no\", \"true\" or \"false\""},
+		{name: "flag upper case", arity: Flag, src: "d TRUE;", err: "invalid value \"TRUE\""},
+		{name: "flag letter", arity: Flag, src: "d t;", err: "invalid value \"t\""},
+		{name: "block", arity: TakeBlock, block: true, src: "d { }"},
+		{name: "block required", arity: Take1 | TakeBlock, block: true, src: "d a;", err: "directive \"d\" has no opening \"{\""},
+		{name: "block not allowed", arity: Take1, src: "d a { }", err: "directive \"d\" is not terminated by \";\""},
+		{name: "block with args", arity: NoArgs | Take1 | TakeBlock, block: true, src: "d { } d a { }"},
+		{name: "block with too many args", arity: NoArgs | TakeBlock, block: true, src: "d a { }", err: "invalid number of arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := arityUnmarshal(t, tt.src, func(cfg *Config, handle HandlerFunc, block BlockHandlerFunc) {
+				var err error
+				if tt.block {
+					_, err = cfg.HandleBlock("d", block, tt.arity)
+				} else {
+					_, err = cfg.HandleFunc("d", handle, tt.arity)
+				}
+				wantError(t, err, "")
+			})
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestArityString(t *testing.T) {
+	for a, want := range map[Arity]string{
+		0:                   "Unchecked",
+		NoArgs:              "NoArgs",
+		Take12:              "Take1|Take2",
+		Flag | TakeBlock:    "Flag|TakeBlock",
+		OneOrMore | Take7:   "Take7|OneOrMore",
+		AnyArgs | TwoOrMore: "TwoOrMore|AnyArgs",
+	} {
+		if got := a.String(); got != want {
+			t.Fatalf("%d: got %q, want %q", uint(a), got, want)
+		}
+	}
+}
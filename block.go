@@ -1,96 +1,213 @@
 package config
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
+	"io"
 	"reflect"
 )
 
-func (cfg *Config) createBlock(s *bytes.Buffer) error {
+func (p *parser) createBlock(s *bytes.Buffer) error {
 	// fixed { be close to key like server{
-	if cfg.searchKey && s.Len() > 0 {
-		if err := cfg.getElement(s.String()); err != nil {
+	if p.searchKey && s.Len() > 0 {
+		if err := p.getElement(s.String()); err != nil {
 			return err
 		}
 
 		s.Reset()
-		cfg.inSearchVal()
+		p.inSearchVal()
 	}
 
+	if err := p.checkCommand(p.expand(s.String()), true); err != nil {
+		return err
+	}
+
+	p.condState = condNone
+	p.bkCond = append(p.bkCond, blockNormal)
+
 	// vars
 	vars := make(map[string]string)
-	cfg.vars = append(cfg.vars, cfg.currentVar)
-	cfg.currentVar = vars
+	p.vars = append(p.vars, p.currentVar)
+	p.currentVar = vars
 
-	cfg.inBlock++
+	p.inBlock++
 	//  slice or map?
-	cfg.bkQueue = append(cfg.bkQueue, cfg.bkMulti)
-	cfg.bkMulti = false
-	if cfg.searchVal && s.Len() > 0 && cfg.current.Kind() == reflect.Map {
-		cfg.bkMulti = true
-		if cfg.current.IsNil() {
-			cfg.current.Set(reflect.MakeMap(cfg.current.Type()))
+	p.bkQueue = append(p.bkQueue, p.bkMulti)
+	p.bkMulti = false
+	p.blocks = append(p.blocks, p.key)
+	p.path = append(p.path, p.key)
+	p.uniques = append(p.uniques, "")
+
+	if p.inTree(directiveType) {
+		if err := p.openDirective(s.String()); err != nil {
+			return err
+		}
+		p.inSearchKey()
+		s.Reset()
+		return nil
+	}
+
+	if p.searchVal && s.Len() > 0 && p.current.Kind() == reflect.Map {
+		p.bkMulti = true
+		if p.current.IsNil() {
+			p.current.Set(reflect.MakeMap(p.current.Type()))
 		}
 
-		v := reflect.New(cfg.current.Type().Key())
-		cfg.pushElement(v)
-		err := cfg.set(s.String())
+		p.uniques[len(p.uniques)-1] = p.typ.Tag.Get("unique")
+		v := reflect.New(p.current.Type().Key())
+		p.pushElement(v)
+		p.inMapKey = true
+		err := p.set(p.expand(s.String()))
+		p.inMapKey = false
 		if err != nil {
 			return err
 		}
-		cfg.mapKey = cfg.current
-		cfg.popElement()
-		val := reflect.New(cfg.current.Type().Elem())
-		cfg.pushElement(val)
+		p.mapKey = p.current
+		p.popElement()
+		p.path[len(p.path)-1] = fmt.Sprintf("%s[%s]", p.key, p.clearQuoted(p.expand(s.String())))
+		val := reflect.New(p.current.Type().Elem())
+		p.pushElement(val)
 	}
 
-	if cfg.current.Kind() == reflect.Slice {
-		cfg.pushMultiBlock()
-		n := cfg.current.Len()
-		if cfg.current.Type().Elem().Kind() == reflect.Ptr {
-			ref := reflect.New(cfg.current.Type().Elem().Elem())
-			if err := cfg.init(ref); err != nil {
+	if p.current.Kind() == reflect.Slice {
+		p.pushMultiBlock()
+		p.uniques[len(p.uniques)-1] = p.typ.Tag.Get("unique")
+		n := p.current.Len()
+		p.path[len(p.path)-1] = fmt.Sprintf("%s[%d]", p.key, n)
+		if p.current.Type().Elem().Kind() == reflect.Ptr {
+			ref := reflect.New(p.current.Type().Elem().Elem())
+			if err := p.init(ref); err != nil {
 				return err
 			}
-			cfg.current.Set(reflect.Append(cfg.current, ref))
+			p.current.Set(reflect.Append(p.current, ref))
 		} else {
-			ref := reflect.New(cfg.current.Type().Elem())
-			if err := cfg.init(ref); err != nil {
+			ref := reflect.New(p.current.Type().Elem())
+			if err := p.init(ref); err != nil {
 				return err
 			}
-			cfg.current.Set(reflect.Append(cfg.current, ref.Elem()))
+			p.current.Set(reflect.Append(p.current, ref.Elem()))
 		}
-		cfg.pushElement(cfg.current.Index(n))
+		p.pushElement(p.current.Index(n))
 	}
-	cfg.inSearchKey()
+	p.inSearchKey()
 	s.Reset()
 	return nil
 }
 
-func (cfg *Config) closeBlock(s *bytes.Buffer) {
-	if cfg.bkMulti {
-		val := cfg.current
-		cfg.popElement()
-		if cfg.current.Kind() == reflect.Map {
-			cfg.current.SetMapIndex(cfg.mapKey, val)
+func (p *parser) closeBlock(s *bytes.Buffer) error {
+	kind := p.bkCond[len(p.bkCond)-1]
+	p.bkCond = p.bkCond[:len(p.bkCond)-1]
+	if kind != blockNormal {
+		p.inBlock--
+		p.condState = condNone
+		if kind == blockIf {
+			p.condState = condTaken
+		}
+		p.inSearchKey()
+		return nil
+	}
+
+	p.condState = condNone
+	block := p.blocks[len(p.blocks)-1]
+	p.blocks = p.blocks[:len(p.blocks)-1]
+	p.path = p.path[:len(p.path)-1]
+	unique := p.uniques[len(p.uniques)-1]
+	p.uniques = p.uniques[:len(p.uniques)-1]
+	if p.inTree(blockType) {
+		if err := p.closeDirective(); err != nil {
+			return err
 		}
 	}
-	cfg.popMultiBlock()
+
+	if p.bkMulti {
+		val := p.current
+		p.popElement()
+		if unique != "" {
+			if err := p.checkUnique(unique, block, val); err != nil {
+				return err
+			}
+		}
+		if p.current.Kind() == reflect.Map {
+			p.current.SetMapIndex(p.mapKey, val)
+		}
+	}
+	p.popMultiBlock()
 
 	// vars
-	cfg.currentVar = cfg.vars[len(cfg.vars)-1]
-	cfg.vars = cfg.vars[:len(cfg.vars)-1]
+	p.currentVar = p.vars[len(p.vars)-1]
+	p.vars = p.vars[:len(p.vars)-1]
+
+	p.inBlock--
+	p.popElement()
+	p.inSearchKey()
+	return nil
+}
 
-	cfg.inBlock--
-	cfg.popElement()
-	cfg.inSearchKey()
+func (p *parser) pushMultiBlock() {
+	p.bkQueue = append(p.bkQueue, p.bkMulti)
+	p.bkMulti = true
 }
 
-func (cfg *Config) pushMultiBlock() {
-	cfg.bkQueue = append(cfg.bkQueue, cfg.bkMulti)
-	cfg.bkMulti = true
+func (p *parser) popMultiBlock() {
+	p.bkMulti = p.bkQueue[len(p.bkQueue)-1]
+	p.bkQueue = p.bkQueue[:len(p.bkQueue)-1]
 }
 
-func (cfg *Config) popMultiBlock() {
-	cfg.bkMulti = cfg.bkQueue[len(cfg.bkQueue)-1]
-	cfg.bkQueue = cfg.bkQueue[:len(cfg.bkQueue)-1]
+// captureBlock reads the raw body of a block whose "{" has been read,
+// it stops after the matching "}"
+func (p *parser) captureBlock(reader *bufio.Reader) ([]byte, error) {
+	var body bytes.Buffer
+	depth := 1
+	key := true
+	word := false
+	varBlock := false
+	var last byte
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil, p.error("invalid config file, block not closed by \"}\"")
+		} else if err != nil {
+			return nil, p.error(err.Error())
+		}
+
+		if b == '\n' {
+			p.line++
+		}
+
+		switch {
+		case varBlock:
+			varBlock = b != '}'
+		case key && b == '#':
+			line, _ := reader.ReadBytes('\n')
+			body.WriteByte(b)
+			body.Write(line)
+			p.line++
+			continue
+		case key && b == '}':
+			depth--
+			if depth == 0 {
+				return body.Bytes(), nil
+			}
+			word = false
+		case b == '{' && !key && last == '$':
+			varBlock = true
+		case b == '{':
+			depth++
+			key = true
+			word = false
+		case b == ';':
+			key = true
+			word = false
+		case key && p.delimiter(b):
+			if word {
+				key = false
+			}
+		case key:
+			word = true
+		}
+
+		last = b
+		body.WriteByte(b)
+	}
 }
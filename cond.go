@@ -0,0 +1,223 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// kind of an opened block
+const (
+	blockNormal = iota
+	blockIf
+	blockElse
+)
+
+// result of the last if/else chain in the current block
+const (
+	condNone = iota
+	condTaken
+	condMissed
+)
+
+// openCond opens a block whose directives go to the enclosing block
+func (p *parser) openCond(kind int) {
+	p.inBlock++
+	p.bkCond = append(p.bkCond, kind)
+	p.inSearchKey()
+}
+
+// condClosed reports whether s is a complete "( ... )"
+func (p *parser) condClosed(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return false
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(s)-1 {
+				return false
+			}
+		}
+	}
+
+	return depth == 0 && quote == 0
+}
+
+// startCond is called with the "{" after an if condition
+func (p *parser) startCond(s string, reader *bufio.Reader) error {
+	p.inCond = false
+	if p.skipCond {
+		p.skipCond = false
+		p.inSearchKey()
+		_, err := p.captureBlock(reader)
+		return err
+	}
+
+	ok, err := p.evalCond(s)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		p.openCond(blockIf)
+		return nil
+	}
+
+	p.condState = condMissed
+	p.inSearchKey()
+	_, err = p.captureBlock(reader)
+	return err
+}
+
+// startElse is called with the "{" after else
+func (p *parser) startElse(reader *bufio.Reader) error {
+	p.inElse = false
+	if p.condState == condMissed {
+		p.openCond(blockElse)
+		return nil
+	}
+
+	p.condState = condNone
+	_, err := p.captureBlock(reader)
+	return err
+}
+
+// evalCond evaluates conditions like ($a), (!$a), ($a = b), ($a != b), ($a ~ re), ($a !~ re), ($a ~* re)
+func (p *parser) evalCond(s string) (bool, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return false, p.error("empty condition in \"if\"")
+	}
+
+	left, op, right := p.splitCond(s)
+	if op == "" {
+		not := false
+		if s[0] == '!' {
+			not = true
+			s = strings.TrimSpace(s[1:])
+			if s == "" {
+				return false, p.error("empty condition in \"if\"")
+			}
+		}
+
+		v, ok := p.condValue(s)
+		return (ok && v != "") != not, nil
+	}
+
+	if left == "" || right == "" {
+		return false, p.error("invalid condition \"%s\" in \"if\"", s)
+	}
+
+	v, _ := p.condValue(left)
+	switch op {
+	case "=":
+		return v == p.expand(p.clearQuoted(right)), nil
+	case "!=":
+		return v != p.expand(p.clearQuoted(right)), nil
+	}
+
+	expr := p.clearQuoted(right)
+	if strings.HasSuffix(op, "*") {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false, p.error("invalid regex \"%s\" in \"if\": %s", right, err.Error())
+	}
+
+	return re.MatchString(v) != strings.HasPrefix(op, "!"), nil
+}
+
+// splitCond splits s by the first operator out of quotes
+func (p *parser) splitCond(s string) (string, string, string) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '=', '~':
+			n := i + 1
+			if ch == '~' && n < len(s) && s[n] == '*' {
+				n++
+			}
+			op := s[i:n]
+			start := i
+			if i > 0 && s[i-1] == '!' {
+				op = "!" + op
+				start--
+			}
+			return strings.TrimSpace(s[:start]), op, strings.TrimSpace(s[n:])
+		}
+	}
+
+	return "", "", ""
+}
+
+// condValue returns the value of an operand, a variable which is not defined
+// is empty. Only here $env_NAME reads the environment, values and include
+// paths never expand it.
+func (p *parser) condValue(s string) (string, bool) {
+	if len(s) > 1 && s[0] == '$' {
+		name := s[1:]
+		if len(name) > 2 && name[0] == '{' && name[len(name)-1] == '}' {
+			name = name[1 : len(name)-1]
+		}
+
+		valid := name != ""
+		for i := 0; i < len(name); i++ {
+			if !p.isVarByte(name[i]) {
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			if v, ok := p.lookupVar(name); ok {
+				return v, true
+			}
+			return p.envVar(name)
+		}
+	}
+
+	return p.expand(p.clearQuoted(s)), true
+}
+
+// envVar looks up $env_NAME in the environment
+func (p *parser) envVar(name string) (string, bool) {
+	if !strings.HasPrefix(name, "env_") || len(name) == 4 {
+		return "", false
+	}
+	return os.LookupEnv(name[4:])
+}
@@ -0,0 +1,132 @@
+package config
+
+import "testing"
+
+type condServer struct {
+	Listen string
+}
+
+type condConf struct {
+	Name   string
+	Level  string
+	Server []condServer
+}
+
+func TestCond(t *testing.T) {
+	chain := `
+if ($env = "prod") {
+    name production;
+} else if ($env ~ "^stag") {
+    name staging;
+} else {
+    name other;
+}
+`
+	tests := []struct {
+		name string
+		src  string
+		vars map[string]string
+		want string
+		err  string
+	}{
+		{name: "if", src: chain, vars: map[string]string{"env": "prod"}, want: "production"},
+		{name: "else if", src: chain, vars: map[string]string{"env": "staging"}, want: "staging"},
+		{name: "else", src: chain, vars: map[string]string{"env": "dev"}, want: "other"},
+		{name: "undefined", src: chain, want: "other"},
+		{
+			name: "first taken branch only",
+			src:  `set a 1; if ($a) { name one; } else if ($a) { name two; } else { name three; }`,
+			want: "one",
+		},
+		{name: "not equal", src: `set a x; if ($a != y) { name ne; }`, want: "ne"},
+		{name: "not match", src: `set a x; if ($a !~ ^y) { name nm; }`, want: "nm"},
+		{name: "case insensitive", src: `set a PROD; if ($a ~* ^prod$) { name ci; }`, want: "ci"},
+		{name: "negation", src: `if (!$a) { name unset; }`, want: "unset"},
+		{name: "empty is false", src: `if ($a) { name set; } else { name empty; }`, vars: map[string]string{"a": ""}, want: "empty"},
+		{name: "set in branch", src: `set a 1; if ($a) { set b 2; } name $b;`, want: "2"},
+		{
+			name: "else after directive",
+			src:  `if ($a) { name a; } level x; else { name b; }`,
+			err:  "\"else\" without \"if\"",
+		},
+		{
+			name: "else after block",
+			src:  `if ($a) { name a; } server { listen 80; } else { name b; }`,
+			err:  "\"else\" without \"if\"",
+		},
+		{name: "else alone", src: `else { name b; }`, err: "\"else\" without \"if\""},
+		{name: "else directive", src: `if ($a) { } else name b;`, err: "\"else\" must be followed by \"if\" or a block"},
+		{name: "if without block", src: `if ($a) name b;`, err: "\"if\" requires a block"},
+		{name: "empty", src: `if () { name a; }`, err: "empty condition in \"if\""},
+		{name: "empty negation", src: `if (!) { name a; }`, err: "empty condition in \"if\""},
+		{name: "invalid regex", src: `set a 1; if ($a ~ "(") { }`, err: "invalid regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &condConf{}
+			err := unmarshalString(t, tt.src, got, func(cfg *Config) {
+				for k, v := range tt.vars {
+					cfg.Variable(k, v)
+				}
+			})
+			wantError(t, err, tt.err)
+			if err == nil && got.Name != tt.want {
+				t.Fatalf("name = %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestCondInBlock(t *testing.T) {
+	src := `
+set env prod;
+server {
+    if ($env = prod) { listen 443; } else { listen 80; }
+}
+server {
+    if ($env != prod) { listen 443; } else { listen 80; }
+}
+`
+	got := &condConf{}
+	err := unmarshalString(t, src, got)
+	wantError(t, err, "")
+	if len(got.Server) != 2 || got.Server[0].Listen != "443" || got.Server[1].Listen != "80" {
+		t.Fatalf("server = %+v", got.Server)
+	}
+}
+
+func TestCondEnvironment(t *testing.T) {
+	t.Setenv("CONFIG_TEST_MODE", "debug")
+
+	got := &condConf{}
+	err := unmarshalString(t, `if ($env_CONFIG_TEST_MODE = debug) { level debug; }`, got)
+	wantError(t, err, "")
+	if got.Level != "debug" {
+		t.Fatalf("level = %q, want debug", got.Level)
+	}
+}
+
+func TestCondEnvironmentOnly(t *testing.T) {
+	t.Setenv("CONFIG_TEST_SECRET", "secret")
+
+	dir := writeFiles(t, map[string]string{
+		"a.conf":      "name $env_CONFIG_TEST_SECRET;\ninclude_optional ${env_CONFIG_TEST_SECRET}.conf;\n",
+		"secret.conf": "level leaked;\n",
+	})
+
+	// the environment is not expanded in values and include paths
+	got := &condConf{}
+	wantError(t, unmarshalFile(t, dir, "a.conf", got), "")
+	if got.Name != "$env_CONFIG_TEST_SECRET" || got.Level != "" {
+		t.Fatalf("got %+v", got)
+	}
+
+	// a variable of the same name comes first in conditions
+	got = &condConf{}
+	err := unmarshalString(t, `set env_CONFIG_TEST_SECRET x; if ($env_CONFIG_TEST_SECRET = x) { level set; }`, got)
+	wantError(t, err, "")
+	if got.Level != "set" {
+		t.Fatalf("level = %q, want set", got.Level)
+	}
+}
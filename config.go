@@ -1,374 +1,251 @@
 package config
 
 import (
-	"bufio"
-	"bytes"
-	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"reflect"
-	"strings"
 	"sync"
 )
 
 type Configurable struct {
 	Runnable bool
 	config   reflect.Value
+
+	name     string
+	arity    Arity
+	contexts []string
+
+	// handle and handleBlock are set for directives added by HandleFunc and
+	// HandleBlock
+	handle      HandlerFunc
+	handleBlock BlockHandlerFunc
+
+	// dynamic is set when it's registered while parsing
+	dynamic bool
+	// seen is the first use in the last parse
+	seen *position
+}
+
+// options are the settings of a Config, each parse works on a copy
+type options struct {
+	camel         bool
+	variables     map[string]string
+	profile       string
+	maxLoop       int
+	maxUse        int
+	maxInclude    int
+	strictInclude bool
+	includePaths  []string
+	// fsIncludePaths are the include paths in the fs.FS of WithFS
+	fsIncludePaths []string
+	includeRoots   []string
+	maxFiles       int
+	maxBytes       int64
+	fsys           fs.FS
+	formats        map[string]reflect.Value
+	hooks          map[string]reflect.Value
 }
 
 // Config A Config struct
 type Config struct {
 	sync.Mutex
-	filename       string
-	queue          []reflect.Value
-	current        reflect.Value
-	entry          reflect.Value
-	typ            reflect.StructField
-	searchVal      bool
-	searchKey      bool
-	inBlock        int
-	inInclude      int
-	canSkip        bool
-	skip           bool
-	bkQueue        []bool
-	bkMulti        bool
-	mapKey         reflect.Value
-	setVar         bool
-	searchVar      bool
-	searchVarBlock bool
-	vars           []map[string]string
-	currentVar     map[string]string
-	camel          bool
-	directives     map[string]*Configurable
-	cwd            string
-	file           *os.File
-	line           int64
-	stash          []*stash
-	format         *format
-	hook           *hook
+	options
+	filename   string
+	entry      reflect.Value
+	target     reflect.Value
+	directives map[string]*Configurable
+	includes   []Include
+	running    int
+	modules    map[string]Module
+	loaded     map[string]bool
+	data       []byte
+	err        error
+
+	// Parse and Reload decode into the same value, one at a time
+	parsing sync.Mutex
 }
 
-// New a config parser with filename
-func New(filename string) *Config {
-	conf := &Config{filename: filename, camel: true}
+// New a config parser with filename, an error of the options is returned by
+// the first parse
+func New(filename string, opts ...Option) *Config {
+	conf := &Config{filename: filename}
+	conf.camel = true
 	conf.directives = make(map[string]*Configurable)
-	conf.format = &format{}
-	conf.hook = &hook{conf}
+	conf.modules = make(map[string]Module)
+	conf.loaded = make(map[string]bool)
+	conf.variables = make(map[string]string)
+	conf.maxLoop = DefaultMaxLoop
+	conf.maxUse = DefaultMaxUse
+	conf.maxInclude = DefaultMaxInclude
+	conf.strictInclude = true
+	conf.err = conf.apply(opts...)
 	return conf
 }
 
 // Config.AutoCamel auto replace _ to camel
+//
+// Deprecated: use WithAutoCamel.
 func (cfg *Config) AutoCamel(b bool) {
-	cfg.camel = b
+	cfg.apply(WithAutoCamel(b))
+}
+
+// Config.Variable inject a variable, it can be used as $name and tested by if
+//
+// Deprecated: use WithVariable.
+func (cfg *Config) Variable(name, value string) {
+	cfg.apply(WithVariable(name, value))
+}
+
+// Config.Profile select the active profile, CONFIG_PROFILE is used if it's not set
+//
+// Deprecated: use WithProfile.
+func (cfg *Config) Profile(name string) {
+	cfg.apply(WithProfile(name))
+}
+
+// Config.MaxLoop set the limit of total iterations of for loops in one parse
+//
+// Deprecated: use WithMaxLoop.
+func (cfg *Config) MaxLoop(n int) {
+	cfg.apply(WithMaxLoop(n))
+}
+
+// Config.MaxIncludeDepth set the limit of nested includes, 0 is unlimited
+//
+// Deprecated: use WithMaxIncludeDepth.
+func (cfg *Config) MaxIncludeDepth(n int) {
+	cfg.apply(WithMaxIncludeDepth(n))
+}
+
+// Config.StrictInclude set whether an include matching no file is an error, default true
+//
+// Deprecated: use WithStrictInclude.
+func (cfg *Config) StrictInclude(b bool) {
+	cfg.apply(WithStrictInclude(b))
+}
+
+// Config.IncludePaths set directories searched in order for relative includes,
+// after the directory of the including file
+//
+// Deprecated: use WithIncludePaths.
+func (cfg *Config) IncludePaths(dirs ...string) error {
+	return cfg.apply(WithIncludePaths(dirs...))
+}
+
+// Config.AllowIncludeRoots refuse included files out of roots, symlinks are resolved
+//
+// Deprecated: use WithIncludeRoots.
+func (cfg *Config) AllowIncludeRoots(roots ...string) error {
+	return cfg.apply(WithIncludeRoots(roots...))
+}
+
+// Config.MaxIncludeFiles set the limit of included files in one parse, 0 is unlimited
+//
+// Deprecated: use WithMaxIncludeFiles.
+func (cfg *Config) MaxIncludeFiles(n int) {
+	cfg.apply(WithMaxIncludeFiles(n))
+}
+
+// Config.MaxBytes set the limit of bytes read in one parse, 0 is unlimited
+//
+// Deprecated: use WithMaxBytes.
+func (cfg *Config) MaxBytes(n int64) {
+	cfg.apply(WithMaxBytes(n))
+}
+
+// Config.Includes returns the includes of the last parse
+func (cfg *Config) Includes() []Include {
+	cfg.Lock()
+	defer cfg.Unlock()
+	includes := make([]Include, len(cfg.includes))
+	copy(includes, cfg.includes)
+	return includes
 }
 
 // Config.Entry set an entry for parser
 func (cfg *Config) Entry(entry interface{}) error {
-	if cfg.entry.IsValid() {
-		return cfg.error("entry already set")
-	}
-	var err error
-	cfg.entry, err = cfg.valueOf(entry)
+	rev, err := cfg.newParser().valueOf(entry)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// Config.Directive add a directive for paser
-func (cfg *Config) Directive(directive string, conf interface{}) (*Configurable, error) {
 	cfg.Lock()
 	defer cfg.Unlock()
+	if cfg.entry.IsValid() {
+		return cfg.error("entry already set")
+	}
+	cfg.entry = rev
 
-	directive = cfg.fixedField(directive)
-	rev, err := cfg.valueOf(conf)
+	return nil
+}
+
+// Config.Directive add a directive for paser, it's allowed at the top level
+// unless Contexts are given
+func (cfg *Config) Directive(directive string, conf interface{}, opts ...DirectiveOption) (*Configurable, error) {
+	config, err := cfg.configurable(DirectiveSpec{Name: directive, Value: conf, Options: opts})
 	if err != nil {
 		return nil, err
 	}
 
-	config := &Configurable{Runnable: false, config: rev}
-	if _, ok := cfg.directives[directive]; ok {
-		return nil, cfg.error("directive [ %s ] duplication", directive)
-	} else {
-		cfg.directives[directive] = config
-	}
-
-	return config, nil
+	return cfg.register(config)
 }
 
 // Config.Parse  parse config with Entry and directive from file
 func (cfg *Config) Parse() error {
-	if !cfg.entry.IsValid() || cfg.entry.IsZero() {
+	cfg.parsing.Lock()
+	defer cfg.parsing.Unlock()
+
+	p := cfg.newParser()
+	if !p.entry.IsValid() || p.entry.IsZero() {
 		return cfg.error("entry be required")
 	}
 
-	cfg.current = reflect.Value{}
-
-	cfg.reset()
-
-	if err := cfg.parse(); err != nil {
-		return err
-	}
+	cfg.Lock()
+	cfg.target = reflect.Value{}
+	cfg.Unlock()
 
-	cfg.current = reflect.Value{}
-	return nil
+	return p.load(reflect.Value{})
 }
 
-// Config.Unmarshal  unmarshal config file to v
+// Config.Unmarshal  unmarshal config file to v, it's safe to unmarshal into
+// different values at once
 func (cfg *Config) Unmarshal(v interface{}) error {
-	var err error
-	if cfg.current, err = cfg.valueOf(v); err != nil {
+	p := cfg.newParser()
+	target, err := p.valueOf(v)
+	if err != nil {
 		return err
 	}
 
-	cfg.reset()
+	// a Reload meanwhile doesn't decode into the value being parsed
+	err = p.load(target)
 
-	return cfg.parse()
-}
+	cfg.Lock()
+	cfg.target = target
+	cfg.Unlock()
 
-// Config.Reload reload config file
-func (cfg *Config) Reload() error {
-	cfg.reset()
-	return cfg.parse()
+	return err
 }
 
-func (cfg *Config) parse() error {
-	var err error
-	var s bytes.Buffer
-	var vs bytes.Buffer
-	var vsb bytes.Buffer
-	if _, err = os.Stat(cfg.filename); os.IsNotExist(err) {
-		return cfg.error(err.Error())
-	}
-
-	filename, err := filepath.Abs(cfg.filename)
+// decode parses the config into v like Unmarshal, but keeps the value of Reload
+func (cfg *Config) decode(v interface{}) error {
+	p := cfg.newParser()
+	target, err := p.valueOf(v)
 	if err != nil {
-		return cfg.error(err.Error())
-	} else {
-		cfg.cwd = filepath.Dir(filename)
+		return err
 	}
 
-	var file *os.File
-	file, err = os.Open(filename)
-	if err != nil {
-		return cfg.error(err.Error())
-	}
-	defer func() {
-		file.Close()
-	}()
-
-	cfg.filename = file.Name()
-	cfg.file = file
-
-	cfg.line = 1
-
-	reader := bufio.NewReader(cfg.file)
-	var b byte
-	for {
-		b, err = reader.ReadByte()
-		if err == io.EOF {
-			if !cfg.searchKey {
-				return cfg.error("invalid config vaild")
-			}
-
-			if s.Len() > 0 {
-				return cfg.error("\"%s\" directive is not allowed here", s.String())
-			}
-
-			if err := cfg.popStash(); err != nil {
-				return err
-			}
-			break
-		} else if err != nil {
-			return cfg.error(err.Error())
-		}
-
-		// bug in \r
-		if b == '\n' {
-			cfg.line++
-		}
-
-		if cfg.canSkip && b == '#' {
-			reader.ReadLine()
-			cfg.line++
-			continue
-		}
-		if cfg.canSkip && b == '/' {
-			if cfg.skip {
-				reader.ReadLine()
-				cfg.line++
-				cfg.skip = false
-				continue
-			}
-			cfg.skip = true
-			continue
-		}
-		if cfg.searchKey {
-			if b == ';' {
-				return cfg.error("unknown directive \"" + s.String() + "\"")
-			}
-
-			if cfg.delimiter(b) {
-				if s.Len() > 0 {
-					cfg.inSearchVal()
-					if strings.Compare(s.String(), "include") == 0 {
-						s.Reset()
-						cfg.inInclude++
-						if cfg.inInclude > 100 {
-							return cfg.error("too many include, exceeds 100 limit")
-						}
-						continue
-					} else if strings.Compare(s.String(), "set") == 0 {
-						s.Reset()
-						cfg.setVar = true
-						continue
-					}
-					if err := cfg.getElement(s.String()); err != nil {
-						return err
-					}
-					s.Reset()
-				}
-				continue
-			}
-		}
-
-		if b == '{' && !cfg.searchVar && vs.Len() == 0 {
-			if err := cfg.createBlock(&s); err != nil {
-				return err
-			}
-			continue
-		}
-
-		if cfg.searchKey && b == '}' && cfg.inBlock > 0 {
-			cfg.closeBlock(&s)
-			continue
-		}
-
-		if cfg.searchVal {
-			if b == '$' {
-				cfg.searchVar = true
-				vs.Reset()
-				vsb.Reset()
-				vsb.WriteByte(b)
-				continue
-			}
-
-			if cfg.searchVar {
-				if b == '{' {
-					if vs.Len() == 0 {
-						cfg.searchVarBlock = true
-						vsb.WriteByte(b)
-						continue
-					}
-					if !cfg.searchVarBlock {
-						if !cfg.replace(&s, &vs) {
-							s.Write(vsb.Bytes())
-						}
-
-						// is block?
-						if err := cfg.createBlock(&s); err != nil {
-							return err
-						} else {
-							continue
-						}
-					}
-				} // if b == '{'
-				// Is space
-				if cfg.delimiter(b) {
-					if !cfg.replace(&s, &vs) {
-						s.Write(vsb.Bytes())
-					}
-				}
-			}
-
-			if cfg.searchVarBlock && b == '}' {
-				cfg.searchVarBlock = false
-				// replace $???
-				cfg.searchVar = false
-				if !cfg.replace(&s, &vs) {
-					vsb.WriteByte(b)
-					s.Write(vsb.Bytes())
-				}
-				continue
-			}
-
-			if b == ';' {
-				if s.Len() < 1 {
-					return cfg.error("unknown value of  directive \"" + s.String() + "\"")
-				}
-				//  copy to cfg.current
-				cfg.inSearchKey()
-				if cfg.searchVar {
-					if cfg.searchVarBlock {
-						return cfg.error(vsb.String() + " is not terminated by }")
-					}
-					cfg.searchVar = false
-					cfg.searchVarBlock = false
-					if !cfg.replace(&s, &vs) {
-						s.Write(vsb.Bytes())
-					}
-				}
-
-				// set to map
-				if cfg.setVar {
-					sf := strings.Fields(s.String())
-					if len(sf) != 2 {
-						return cfg.error("set map with %s invalid", s.String())
-					}
-					cfg.currentVar[sf[0]] = sf[1]
-					cfg.setVar = false
-					s.Reset()
-					continue
-				}
-
-				if cfg.inInclude > 0 {
-					cfg.filename = strings.TrimSpace(s.String())
-					s.Reset()
-					cfg.inInclude--
-					var files []string
-					if filepath.IsAbs(cfg.filename) {
-						files, err = filepath.Glob(cfg.filename)
-					} else {
-						files, err = filepath.Glob(filepath.Join(cfg.cwd, cfg.filename))
-					}
-					if err != nil {
-						return cfg.error(err.Error())
-					}
-					for _, file := range files {
-						cfg.pushStash()
-						cfg.filename = file
-						if err := cfg.parse(); err != nil {
-							return err
-						}
-					}
-					continue
-				}
-
-				err := cfg.set(s.String())
-				if err != nil {
-					return err
-				}
-
-				s.Reset()
-				cfg.popElement()
-				continue
-			} else if cfg.searchVar { // if b == ';'
-				vs.WriteByte(b)
-				vsb.WriteByte(b)
-				if !cfg.searchVarBlock {
-					cfg.replace(&s, &vs)
-				}
-				continue
-			}
-		}
-
-		s.WriteByte(b)
-	}
+	return p.load(target)
+}
 
-	if !cfg.searchKey && cfg.inBlock > 0 {
-		return cfg.error("invalid config file")
-	}
+// Config.Reload reload config file into the value of the last Unmarshal, or
+// the entry of Parse. The value is changed in place and must not be read
+// meanwhile, with concurrent calls to Unmarshal it's the value of the last one.
+func (cfg *Config) Reload() error {
+	cfg.parsing.Lock()
+	defer cfg.parsing.Unlock()
 
-	return nil
+	cfg.Lock()
+	target := cfg.target
+	cfg.Unlock()
+
+	return cfg.newParser().load(target)
 }
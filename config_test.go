@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type raceConf struct {
+	Name   string
+	Zone   []string
+	Server []includeServer
+}
+
+func raceFiles(t *testing.T) string {
+	t.Helper()
+
+	return writeFiles(t, map[string]string{
+		"a.conf": `
+name a;
+include conf.d/*.conf;
+for $i in 0..9 {
+    server { listen $i; include common.conf; }
+}
+`,
+		"conf.d/b.conf": "zone b;\n",
+		"conf.d/c.conf": "zone c;\n",
+		"common.conf":   "port 80;\n",
+	})
+}
+
+func checkRaceConf(c *raceConf) error {
+	if c.Name != "a" || len(c.Zone) != 2 || len(c.Server) != 10 {
+		return fmt.Errorf("got name %q, %d zones, %d servers", c.Name, len(c.Zone), len(c.Server))
+	}
+	for i, s := range c.Server {
+		if s.Listen != fmt.Sprint(i) || s.Port != "80" {
+			return fmt.Errorf("server[%d] = %+v", i, s)
+		}
+	}
+	return nil
+}
+
+func TestConcurrentUnmarshal(t *testing.T) {
+	cfg := New(filepath.Join(raceFiles(t), "a.conf"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				v := &raceConf{}
+				if err := cfg.Unmarshal(v); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := checkRaceConf(v); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReloadDuringUnmarshal(t *testing.T) {
+	cfg := New(filepath.Join(raceFiles(t), "a.conf"))
+	wantError(t, cfg.Unmarshal(&raceConf{}), "")
+
+	// Reload decodes into the value of the last Unmarshal, the values are
+	// checked once nothing reloads
+	values := make([]*raceConf, 32)
+	var wg sync.WaitGroup
+	for i := range values {
+		values[i] = &raceConf{}
+		wg.Add(1)
+		go func(v *raceConf) {
+			defer wg.Done()
+			if err := cfg.Unmarshal(v); err != nil {
+				t.Error(err)
+			}
+		}(values[i])
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := cfg.Reload(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a value reloaded after its Unmarshal has the servers twice, but never
+	// mixed with the ones of another parse
+	for _, v := range values {
+		if v.Name != "a" || len(v.Server) == 0 || len(v.Server)%10 != 0 {
+			t.Fatalf("got name %q, %d servers", v.Name, len(v.Server))
+		}
+		for i, s := range v.Server {
+			if s.Listen != fmt.Sprint(i%10) {
+				t.Fatalf("server[%d] = %+v", i, s)
+			}
+		}
+	}
+}
+
+func TestInspectDuringParse(t *testing.T) {
+	cfg := New(filepath.Join(raceFiles(t), "a.conf"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := cfg.Unmarshal(&raceConf{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	var inspect sync.WaitGroup
+	inspect.Add(1)
+	go func() {
+		defer inspect.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			for _, inc := range cfg.Includes() {
+				if inc.Pattern != "conf.d/*.conf" && inc.Pattern != "common.conf" {
+					t.Errorf("unexpected include %+v", inc)
+					return
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	inspect.Wait()
+
+	if n := len(cfg.Includes()); n != 11 {
+		t.Fatalf("%d includes, want 11", n)
+	}
+}
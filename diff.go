@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ChangeKind is the kind of a change between two configs
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change is a difference between two configs
+type Change struct {
+	// Path is the path of the directive, like server[1].port
+	Path string
+	Old  interface{}
+	New  interface{}
+	Kind ChangeKind
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %v", c.Path, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s: %v", c.Path, c.Old)
+	}
+	return fmt.Sprintf("~ %s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// FormatChanges renders changes one per line
+func FormatChanges(changes []Change) string {
+	var buf bytes.Buffer
+	for _, c := range changes {
+		buf.WriteString(c.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// Diff returns the changes from old to new, both are values or pointers of
+// the same type as given to Unmarshal
+func Diff(old, new interface{}) []Change {
+	var changes []Change
+	diffValue(&changes, "", reflect.ValueOf(old), reflect.ValueOf(new))
+	return changes
+}
+
+func diffValue(changes *[]Change, path string, old, new reflect.Value) {
+	for old.IsValid() && (old.Kind() == reflect.Ptr || old.Kind() == reflect.Interface) && !old.IsNil() {
+		old = old.Elem()
+	}
+	for new.IsValid() && (new.Kind() == reflect.Ptr || new.Kind() == reflect.Interface) && !new.IsNil() {
+		new = new.Elem()
+	}
+
+	oldNil := !old.IsValid() || ((old.Kind() == reflect.Ptr || old.Kind() == reflect.Interface) && old.IsNil())
+	newNil := !new.IsValid() || ((new.Kind() == reflect.Ptr || new.Kind() == reflect.Interface) && new.IsNil())
+	switch {
+	case oldNil && newNil:
+		return
+	case oldNil:
+		*changes = append(*changes, Change{Path: path, New: valueOf(new), Kind: Added})
+		return
+	case newNil:
+		*changes = append(*changes, Change{Path: path, Old: valueOf(old), Kind: Removed})
+		return
+	}
+
+	if old.Type() != new.Type() || isLeaf(old) {
+		if !reflect.DeepEqual(valueOf(old), valueOf(new)) {
+			*changes = append(*changes, Change{Path: path, Old: valueOf(old), New: valueOf(new), Kind: Modified})
+		}
+		return
+	}
+
+	switch old.Kind() {
+	case reflect.Struct:
+		typ := old.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			diffValue(changes, joinPath(path, directiveName(field.Name)), old.Field(i), new.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		n := old.Len()
+		if new.Len() > n {
+			n = new.Len()
+		}
+		for i := 0; i < n; i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= old.Len():
+				*changes = append(*changes, Change{Path: p, New: valueOf(new.Index(i)), Kind: Added})
+			case i >= new.Len():
+				*changes = append(*changes, Change{Path: p, Old: valueOf(old.Index(i)), Kind: Removed})
+			default:
+				diffValue(changes, p, old.Index(i), new.Index(i))
+			}
+		}
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range old.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for _, k := range new.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			k := keys[name]
+			diffValue(changes, fmt.Sprintf("%s[%s]", path, name), old.MapIndex(k), new.MapIndex(k))
+		}
+	}
+}
+
+// isLeaf reports whether v is compared as a whole
+func isLeaf(v reflect.Value) bool {
+	if v.CanInterface() {
+		if _, ok := v.Interface().(encoding.TextMarshaler); ok {
+			return true
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		return v.Type().Elem().Kind() == reflect.Uint8
+	case reflect.Map:
+		return false
+	}
+
+	return true
+}
+
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// directiveName returns the directive of a field, LogFile is log_file
+func directiveName(field string) string {
+	var buf bytes.Buffer
+	runes := []rune(field)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && runes[i-1] != '_' {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				buf.WriteByte('_')
+			}
+		}
+		buf.WriteRune(unicode.ToLower(r))
+	}
+	return strings.ReplaceAll(buf.String(), "__", "_")
+}
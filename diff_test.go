@@ -0,0 +1,168 @@
+package config
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type diffServer struct {
+	Listen string
+	Port   int
+}
+
+type diffConf struct {
+	Name    string
+	LogFile string
+	Timeout time.Duration
+	IP      net.IP
+	Tags    []string
+	Server  []diffServer
+	Limits  map[string]int
+	Backup  *diffServer
+
+	hidden string
+}
+
+func TestDiff(t *testing.T) {
+	base := func() *diffConf {
+		return &diffConf{
+			Name:    "a",
+			LogFile: "a.log",
+			Timeout: time.Second,
+			IP:      net.ParseIP("10.0.0.1"),
+			Tags:    []string{"x", "y"},
+			Server:  []diffServer{{Listen: "x", Port: 80}, {Listen: "y", Port: 81}},
+			Limits:  map[string]int{"conn": 10, "rate": 5},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *diffConf)
+		want   []Change
+	}{
+		{name: "equal", change: func(c *diffConf) { c.hidden = "x" }},
+		{
+			name:   "field",
+			change: func(c *diffConf) { c.LogFile = "b.log" },
+			want:   []Change{{Path: "log_file", Old: "a.log", New: "b.log", Kind: Modified}},
+		},
+		{
+			name:   "duration",
+			change: func(c *diffConf) { c.Timeout = time.Minute },
+			want:   []Change{{Path: "timeout", Old: time.Second, New: time.Minute, Kind: Modified}},
+		},
+		{
+			name:   "text marshaler",
+			change: func(c *diffConf) { c.IP = net.ParseIP("10.0.0.2") },
+			want:   []Change{{Path: "ip", Old: "10.0.0.1", New: "10.0.0.2", Kind: Modified}},
+		},
+		{
+			name:   "slice element",
+			change: func(c *diffConf) { c.Tags[1] = "z" },
+			want:   []Change{{Path: "tags[1]", Old: "y", New: "z", Kind: Modified}},
+		},
+		{
+			name:   "slice added",
+			change: func(c *diffConf) { c.Tags = append(c.Tags, "z") },
+			want:   []Change{{Path: "tags[2]", New: "z", Kind: Added}},
+		},
+		{
+			name:   "slice removed",
+			change: func(c *diffConf) { c.Tags = c.Tags[:1] },
+			want:   []Change{{Path: "tags[1]", Old: "y", Kind: Removed}},
+		},
+		{
+			name:   "block field",
+			change: func(c *diffConf) { c.Server[1].Port = 8081 },
+			want:   []Change{{Path: "server[1].port", Old: 81, New: 8081, Kind: Modified}},
+		},
+		{
+			name:   "block removed",
+			change: func(c *diffConf) { c.Server = c.Server[:1] },
+			want:   []Change{{Path: "server[1]", Old: diffServer{Listen: "y", Port: 81}, Kind: Removed}},
+		},
+		{
+			name: "map",
+			change: func(c *diffConf) {
+				c.Limits["conn"] = 20
+				delete(c.Limits, "rate")
+				c.Limits["body"] = 1
+			},
+			want: []Change{
+				{Path: "limits[body]", New: 1, Kind: Added},
+				{Path: "limits[conn]", Old: 10, New: 20, Kind: Modified},
+				{Path: "limits[rate]", Old: 5, Kind: Removed},
+			},
+		},
+		{
+			name:   "pointer added",
+			change: func(c *diffConf) { c.Backup = &diffServer{Listen: "z"} },
+			want:   []Change{{Path: "backup", New: diffServer{Listen: "z"}, Kind: Added}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := base(), base()
+			tt.change(new)
+
+			got := Diff(old, new)
+			if len(got) != len(tt.want) {
+				t.Fatalf("changes = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if !sameChange(got[i], tt.want[i]) {
+					t.Fatalf("changes = %v, want %v", got, tt.want)
+				}
+			}
+
+			// the changes back are the opposite ones
+			if back := Diff(new, old); len(back) != len(got) {
+				t.Fatalf("changes back = %v", back)
+			}
+		})
+	}
+}
+
+// sameChange compares c with want, values are compared by their text
+func sameChange(c, want Change) bool {
+	return c.Path == want.Path && c.Kind == want.Kind && c.String() == want.String()
+}
+
+func TestFormatChanges(t *testing.T) {
+	changes := []Change{
+		{Path: "server[1].port", Old: 80, New: 8080, Kind: Modified},
+		{Path: "tags[2]", New: "debug", Kind: Added},
+		{Path: "limits[rate]", Old: 5, Kind: Removed},
+	}
+	want := "~ server[1].port: 80 -> 8080\n+ tags[2]: debug\n- limits[rate]: 5\n"
+	if got := FormatChanges(changes); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := FormatChanges(nil); got != "" {
+		t.Fatalf("got %q, want empty", got)
+	}
+
+	for kind, want := range map[ChangeKind]string{Added: "added", Removed: "removed", Modified: "modified", ChangeKind(9): "unknown"} {
+		if kind.String() != want {
+			t.Fatalf("%d = %q, want %q", kind, kind.String(), want)
+		}
+	}
+}
+
+func TestDirectiveName(t *testing.T) {
+	for field, want := range map[string]string{
+		"Name":       "name",
+		"LogFile":    "log_file",
+		"Log_file":   "log_file",
+		"HTTPServer": "http_server",
+		"IP":         "ip",
+		"Listen6":    "listen6",
+	} {
+		if got := directiveName(field); got != want {
+			t.Fatalf("%s: got %q, want %q", field, got, want)
+		}
+	}
+}
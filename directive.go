@@ -0,0 +1,202 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Block is a block of directives kept as they are written, it's given to
+// block handlers, and can be the type of a field to keep a block undecoded
+type Block struct {
+	Directives []*Directive
+
+	owner *Directive
+}
+
+// Directive is a directive of a Block, Args are substituted and unquoted
+type Directive struct {
+	Name  string
+	Args  []string
+	Block *Block
+	File  string
+	Line  int64
+
+	handler *Configurable
+	// unmarshaler is the field decoding the directive by UnmarshalConfig
+	unmarshaler ConfigUnmarshaler
+}
+
+// Block.Find returns the directives named name, none for a nil block
+func (b *Block) Find(name string) []*Directive {
+	if b == nil {
+		return nil
+	}
+
+	var found []*Directive
+	for _, d := range b.Directives {
+		if d.Name == name {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// DirectiveContext is given to a handler of a directive
+type DirectiveContext struct {
+	Name string
+	// Args are the arguments after variable substitution
+	Args []string
+	// File and Line are the position of the directive
+	File string
+	Line int64
+	// Path is the names of the enclosing blocks, empty at the top level
+	Path []string
+
+	p *parser
+}
+
+// HandlerFunc handles a simple directive, "name args;"
+type HandlerFunc func(ctx *DirectiveContext, args []string) error
+
+// BlockHandlerFunc handles a block directive, "name args { ... }"
+type BlockHandlerFunc func(ctx *DirectiveContext, args []string, block *Block) error
+
+// Config.HandleFunc add a directive handled by fn, it's called for each
+// occurrence in any block where no field has the name, unless Contexts are given
+func (cfg *Config) HandleFunc(directive string, fn HandlerFunc, opts ...DirectiveOption) (*Configurable, error) {
+	config, err := cfg.configurable(DirectiveSpec{Name: directive, Handle: fn, Options: opts})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.register(config)
+}
+
+// Config.HandleBlock add a block directive handled by fn, it's called when
+// the block is closed
+func (cfg *Config) HandleBlock(directive string, fn BlockHandlerFunc, opts ...DirectiveOption) (*Configurable, error) {
+	config, err := cfg.configurable(DirectiveSpec{Name: directive, HandleBlock: fn, Options: opts})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.register(config)
+}
+
+var (
+	blockType     = reflect.TypeOf(Block{})
+	directiveType = reflect.TypeOf(Directive{})
+)
+
+func (p *parser) inTree(kind reflect.Type) bool {
+	return p.current.IsValid() && p.current.Type() == kind
+}
+
+// newDirective returns a directive at the current position
+func (p *parser) newDirective(name string) *Directive {
+	return &Directive{Name: name, File: p.filename, Line: p.line}
+}
+
+// setDirective sets the arguments of a directive without a block
+func (p *parser) setDirective(s string) error {
+	d := p.current.Addr().Interface().(*Directive)
+	args, err := p.splitQuoted(s)
+	if err != nil {
+		return err
+	}
+	d.Args = args
+
+	if d.unmarshaler != nil {
+		return p.runUnmarshaler(d)
+	}
+	if d.handler == nil {
+		return nil
+	}
+	if err := p.checkArity(d.handler, d.Name, args, false); err != nil {
+		return err
+	}
+
+	return p.runHandler(d)
+}
+
+// bareDirective handles a directive without arguments
+func (p *parser) bareDirective(s string) error {
+	if err := p.getElement(s); err != nil {
+		return err
+	}
+	if !p.inTree(directiveType) {
+		return p.error("unknown directive \"%s\"", s)
+	}
+	if err := p.checkCommand("", false); err != nil {
+		return err
+	}
+	if err := p.setDirective(""); err != nil {
+		return err
+	}
+	p.popElement()
+	return nil
+}
+
+// isHandler reports whether s is a directive added by HandleFunc or HandleBlock
+func (p *parser) isHandler(s string) bool {
+	rev, ok := p.directive(p.fixedField(s))
+	return ok && (rev.handle != nil || rev.handleBlock != nil)
+}
+
+// openDirective starts the block of a directive, s is the arguments before "{"
+func (p *parser) openDirective(s string) error {
+	d := p.current.Addr().Interface().(*Directive)
+	args, err := p.splitQuoted(p.expand(s))
+	if err != nil {
+		return err
+	}
+	d.Args = args
+
+	if d.handler != nil {
+		if err := p.checkArity(d.handler, d.Name, args, true); err != nil {
+			return err
+		}
+	}
+	d.Block = &Block{owner: d}
+	p.current = reflect.ValueOf(d.Block).Elem()
+
+	return nil
+}
+
+// closeDirective runs the handler or the unmarshaler of a block directive,
+// the block is current
+func (p *parser) closeDirective() error {
+	b := p.current.Addr().Interface().(*Block)
+	if b.owner == nil {
+		return nil
+	}
+	if b.owner.unmarshaler != nil {
+		return p.runUnmarshaler(b.owner)
+	}
+	if b.owner.handler == nil {
+		return nil
+	}
+	return p.runHandler(b.owner)
+}
+
+func (p *parser) runHandler(d *Directive) error {
+	ctx := &DirectiveContext{
+		Name: d.Name,
+		Args: d.Args,
+		File: d.File,
+		Line: d.Line,
+		Path: append([]string(nil), p.blocks...),
+		p:    p,
+	}
+
+	var err error
+	if d.handler.handleBlock != nil {
+		err = d.handler.handleBlock(ctx, d.Args, d.Block)
+	} else {
+		err = d.handler.handle(ctx, d.Args)
+	}
+	if err != nil {
+		return p.wrapError(d.File, d.Line, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type handlerServer struct {
+	Name string
+}
+
+type handlerConf struct {
+	Name string
+	Http struct {
+		Server []handlerServer
+	}
+	Raw Block
+}
+
+func TestHandleFunc(t *testing.T) {
+	src := "set port 80;\nlisten $port;\nhttp {\n    server {\n        listen \"a b\" ${port}1;\n    }\n}\n"
+
+	var got []DirectiveContext
+	err := unmarshalString(t, src, &handlerConf{}, func(cfg *Config) {
+		_, err := cfg.HandleFunc("listen", func(ctx *DirectiveContext, args []string) error {
+			if !reflect.DeepEqual(args, ctx.Args) {
+				t.Errorf("args %q, ctx.Args %q", args, ctx.Args)
+			}
+			got = append(got, *ctx)
+			return nil
+		})
+		wantError(t, err, "")
+	})
+	wantError(t, err, "")
+
+	want := []DirectiveContext{
+		{Name: "listen", Args: []string{"80"}, Line: 2},
+		{Name: "listen", Args: []string{"a b", "801"}, Line: 5, Path: []string{"http", "server"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		g := got[i]
+		if g.Name != want[i].Name || !reflect.DeepEqual(g.Args, want[i].Args) || g.Line != want[i].Line ||
+			filepath.Base(g.File) != "test.conf" || len(g.Path) != len(want[i].Path) {
+			t.Fatalf("call %d = %+v, want %+v", i, g, want[i])
+		}
+		for j := range want[i].Path {
+			if g.Path[j] != want[i].Path[j] {
+				t.Fatalf("path of call %d = %q, want %q", i, g.Path, want[i].Path)
+			}
+		}
+	}
+}
+
+func TestHandleBlock(t *testing.T) {
+	src := `
+cache disk {
+    path /var/cache/a;
+    path /var/cache/b 10m;
+    zone {
+        size 1m;
+    }
+}
+name a;
+`
+	var args []string
+	var block *Block
+	err := unmarshalString(t, src, &handlerConf{}, func(cfg *Config) {
+		_, err := cfg.HandleBlock("cache", func(ctx *DirectiveContext, a []string, b *Block) error {
+			args, block = a, b
+			return nil
+		})
+		wantError(t, err, "")
+	})
+	wantError(t, err, "")
+
+	if len(args) != 1 || args[0] != "disk" || block == nil {
+		t.Fatalf("args %q, block %+v", args, block)
+	}
+	paths := block.Find("path")
+	if len(paths) != 2 || paths[0].Args[0] != "/var/cache/a" || paths[1].Args[1] != "10m" || paths[1].Line != 4 {
+		t.Fatalf("path = %+v", paths)
+	}
+	zones := block.Find("zone")
+	if len(zones) != 1 || zones[0].Block == nil || len(zones[0].Block.Find("size")) != 1 {
+		t.Fatalf("zone = %+v", zones)
+	}
+	if d := block.Find("none"); d != nil {
+		t.Fatalf("none = %+v", d)
+	}
+
+	var nilBlock *Block
+	if d := nilBlock.Find("path"); d != nil {
+		t.Fatalf("Find of a nil block = %+v", d)
+	}
+}
+
+func TestBlockField(t *testing.T) {
+	got := &handlerConf{}
+	err := unmarshalString(t, "raw {\n    a 1 2;\n    b { c; }\n}\n", got)
+	wantError(t, err, "")
+
+	if a := got.Raw.Find("a"); len(a) != 1 || !reflect.DeepEqual(a[0].Args, []string{"1", "2"}) {
+		t.Fatalf("a = %+v", a)
+	}
+	if b := got.Raw.Find("b"); len(b) != 1 || len(b[0].Block.Find("c")) != 1 {
+		t.Fatalf("b = %+v", b)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.conf": "name a;\nextra 1;\n",
+		"b.conf": "name b;\nbad;\n",
+	})
+
+	tests := []struct {
+		name string
+		fn   func(cfg *Config) HandlerFunc
+		err  string
+	}{
+		{
+			name: "error",
+			fn: func(cfg *Config) HandlerFunc {
+				return func(ctx *DirectiveContext, args []string) error { return errors.New("bad extra") }
+			},
+			err: "bad extra in a.conf:2",
+		},
+		{
+			// an error of the Config gets the position of the directive
+			// instead of the name of the file
+			name: "error of the config",
+			fn: func(cfg *Config) HandlerFunc {
+				return func(ctx *DirectiveContext, args []string) error {
+					_, err := cfg.HandleFunc("extra", func(ctx *DirectiveContext, args []string) error { return nil })
+					return err
+				}
+			},
+			err: "directive [ Extra ] duplication in a.conf:2",
+		},
+		{
+			// an error of another parse keeps its position
+			name: "error of a parse",
+			fn: func(cfg *Config) HandlerFunc {
+				return func(ctx *DirectiveContext, args []string) error {
+					return New(filepath.Join(dir, "b.conf")).Unmarshal(&handlerConf{})
+				}
+			},
+			err: "unknown directive \"bad\" in b.conf:2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unmarshalFile(t, dir, "a.conf", &handlerConf{}, func(cfg *Config) {
+				_, err := cfg.HandleFunc("extra", tt.fn(cfg))
+				wantError(t, err, "")
+			})
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -6,63 +6,100 @@ import (
 	"strings"
 )
 
-func (cfg *Config) getElement(s string) error {
-	cfg.Lock()
-	defer cfg.Unlock()
+func (p *parser) getElement(s string) error {
 	s = strings.TrimSpace(s)
+	p.key = s
 
-	if !cfg.current.IsValid() {
-		if ok := cfg.fetchDirective(s); ok {
-			return nil
-		} else {
-			cfg.current = cfg.entry
+	if !p.current.IsValid() {
+		if ok, err := p.fetchDirective(s); err != nil || ok {
+			return err
 		}
+		p.current = p.entry
 	}
 
-	cfg.restoreElement()
+	p.restoreElement()
 
-	if cfg.current.Kind() != reflect.Struct {
-		return cfg.error("unknown directive %s, current kind is %s, but struct required", s, cfg.current.Kind())
+	// a block kept as it is
+	if p.inTree(blockType) {
+		b := p.current.Addr().Interface().(*Block)
+		d := p.newDirective(s)
+		b.Directives = append(b.Directives, d)
+		p.current = reflect.ValueOf(d).Elem()
+		return nil
+	}
+
+	if p.current.Kind() != reflect.Struct {
+		return p.error("unknown directive %s, current kind is %s, but struct required", s, p.current.Kind())
 	}
 
 	// 如果是驼峰，就要处理一下
-	field := cfg.fixedField(s)
+	field := p.fixedField(s)
 
 	var ok bool
-	if cfg.typ, ok = cfg.current.Type().FieldByName(field); !ok {
-		cfg.popElement()
-		if cfg.current == cfg.entry {
-			if ok := cfg.fetchDirective(field); ok {
-				return nil
-			}
+	if p.typ, ok = p.current.Type().FieldByName(field); !ok {
+		p.popElement()
+		if ok, err := p.fetchDirective(field); err != nil || ok {
+			return err
 		}
-		return cfg.error("unknown directive %s ", s)
+		return p.error("unknown directive %s ", s)
 	}
 
-	cfg.current = cfg.current.FieldByName(field)
+	p.current = p.current.FieldByName(field)
+	p.unmarshalDirective(s)
 
 	return nil
 }
 
-func (cfg *Config) fetchDirective(s string) bool {
-	if rev, ok := cfg.directives[s]; ok {
-		rev.Runnable = true
-		cfg.current = rev.config
-		cfg.fixedElement()
-		return true
+// fetchDirective starts a directive added by Directive, HandleFunc or
+// HandleBlock, it's an error out of the contexts of the directive
+func (p *parser) fetchDirective(s string) (bool, error) {
+	rev, ok := p.directive(s)
+	if !ok {
+		return false, nil
+	}
+
+	if !rev.allowed(p.blocks) {
+		return false, p.error("\"%s\" directive is not allowed here", rev.name)
+	}
+
+	p.markSeen(rev)
+	// the tags of the last field don't apply
+	p.typ = reflect.StructField{}
+	if rev.handle != nil || rev.handleBlock != nil {
+		d := p.newDirective(rev.name)
+		d.handler = rev
+		p.pushElement(reflect.ValueOf(d).Elem())
+		return true, nil
 	}
-	return false
+
+	p.pushElement(rev.config)
+	p.fixedElement()
+	p.unmarshalDirective(rev.name)
+	p.command = rev
+	return true, nil
 }
 
-func (cfg *Config) getStruct() (reflect.Value, bool) {
-	if len(cfg.queue) < 1 {
+func (p *parser) getStruct() (reflect.Value, bool) {
+	if len(p.queue) < 1 {
 		return reflect.Value{}, false
 	}
-	return cfg.queue[len(cfg.queue)-1], true
+	return p.queue[len(p.queue)-1], true
+}
+
+// nearestStruct returns the struct of the field being set, the top of the
+// queue is a slice or a map for their elements
+func (p *parser) nearestStruct() (reflect.Value, bool) {
+	for i := len(p.queue) - 1; i >= 0; i-- {
+		if v := reflect.Indirect(p.queue[i]); v.Kind() == reflect.Struct {
+			return v, true
+		}
+	}
+	return reflect.Value{}, false
 }
 
-func (cfg *Config) getMethod(s string) (reflect.Value, bool) {
-	if element, ok := cfg.getStruct(); ok {
+// getMethod returns the method s of the struct of the field being set
+func (p *parser) getMethod(s string) (reflect.Value, bool) {
+	if element, ok := p.nearestStruct(); ok {
 		if element.Kind() != reflect.Ptr && element.CanAddr() {
 			element = element.Addr()
 		}
@@ -75,43 +112,43 @@ func (cfg *Config) getMethod(s string) (reflect.Value, bool) {
 	return reflect.Value{}, false
 }
 
-func (cfg *Config) getNearestSlice() (reflect.Value, bool) {
-	if len(cfg.queue) < 2 {
+func (p *parser) getNearestSlice() (reflect.Value, bool) {
+	if len(p.queue) < 2 {
 		return reflect.Value{}, false
 	}
-	return cfg.queue[len(cfg.queue)-2], true
+	return p.queue[len(p.queue)-2], true
 }
 
-func (cfg *Config) restoreElement() {
-	cfg.fixedElement()
-	cfg.queue = append(cfg.queue, cfg.current)
+func (p *parser) restoreElement() {
+	p.fixedElement()
+	p.queue = append(p.queue, p.current)
 }
 
-func (cfg *Config) fixedElement() {
-	if cfg.current.Kind() == reflect.Ptr {
-		if cfg.current.IsNil() {
-			cfg.current.Set(reflect.New(cfg.current.Type().Elem()))
+func (p *parser) fixedElement() {
+	if p.current.Kind() == reflect.Ptr {
+		if p.current.IsNil() {
+			p.current.Set(reflect.New(p.current.Type().Elem()))
 		}
-		cfg.current = cfg.current.Elem()
+		p.current = p.current.Elem()
 	}
 }
 
-func (cfg *Config) pushElement(v reflect.Value) {
-	cfg.queue = append(cfg.queue, cfg.current)
-	cfg.current = v
+func (p *parser) pushElement(v reflect.Value) {
+	p.queue = append(p.queue, p.current)
+	p.current = v
 }
 
-func (cfg *Config) popElement() {
-	if len(cfg.queue) < 1 {
-		cfg.current = reflect.Value{}
+func (p *parser) popElement() {
+	if len(p.queue) < 1 {
+		p.current = reflect.Value{}
 		return
 	}
-	cfg.current = cfg.queue[len(cfg.queue)-1]
-	cfg.queue = cfg.queue[:len(cfg.queue)-1]
+	p.current = p.queue[len(p.queue)-1]
+	p.queue = p.queue[:len(p.queue)-1]
 }
 
-func (cfg *Config) fixedField(s string) string {
-	if cfg.camel {
+func (o *options) fixedField(s string) string {
+	if o.camel {
 		stmp := strings.Split(s, "_")
 		buffer := bytes.Buffer{}
 		for _, v := range stmp {
@@ -1,10 +1,58 @@
 package config
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// posError is an error of a parse or of a Config, it keeps the message apart
+// from the position so a handler's error is not given a second position
+type posError struct {
+	msg  string
+	file string
+	// line is 0 for an error of a Config
+	line int64
+	via  string
+}
+
+func (e *posError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("%s in %s", e.msg, e.file)
+	}
+	if e.via != "" {
+		return fmt.Sprintf("%s in %s:%d (%s)", e.msg, e.file, e.line, e.via)
+	}
+	return fmt.Sprintf("%s in %s:%d", e.msg, e.file, e.line)
+}
+
+func (p *parser) error(s string, a ...interface{}) error {
+	return p.errorAt(p.filename, p.line, s, a...)
+}
+
+// errorAt reports an error at a position which is not the current one
+func (p *parser) errorAt(filename string, line int64, s string, a ...interface{}) error {
+	if len(a) > 0 {
+		s = fmt.Sprintf(s, a...)
+	}
+	return &posError{msg: s, file: filename, line: line, via: p.via}
+}
+
+// wrapError reports err of a handler or an unmarshaler at a position, an error
+// of a nested parse keeps its own and an error of a Config loses the file name
+func (p *parser) wrapError(filename string, line int64, err error) error {
+	if pe, ok := err.(*posError); ok && pe.line == 0 {
+		return p.errorAt(filename, line, pe.msg)
+	}
+	var pe *posError
+	if errors.As(err, &pe) && pe.line > 0 {
+		return err
+	}
+	return p.errorAt(filename, line, err.Error())
+}
 
 func (cfg *Config) error(s string, a ...interface{}) error {
 	if len(a) > 0 {
 		s = fmt.Sprintf(s, a...)
 	}
-	return fmt.Errorf("%s in %s:%d", s, cfg.filename, cfg.line)
+	return &posError{msg: s, file: cfg.filename}
 }
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// bytesName is the name of a root file given as bytes in errors
+const bytesName = "<bytes>"
+
+// openFile opens p.filename from the disk, or from the fs.FS of WithFS, and
+// sets the directory of relative includes
+func (p *parser) openFile() (io.ReadCloser, error) {
+	if p.data != nil && len(p.stash) == 0 {
+		// the root file is given as bytes, includes are relative to the
+		// working directory
+		p.cwd = "."
+		if p.fsys == nil {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return nil, err
+			}
+			p.cwd = cwd
+		}
+		return io.NopCloser(bytes.NewReader(p.data)), nil
+	}
+
+	if p.fsys != nil {
+		p.filename = path.Clean(strings.TrimPrefix(p.filename, "/"))
+		p.cwd = path.Dir(p.filename)
+		return p.fsys.Open(p.filename)
+	}
+
+	filename, err := filepath.Abs(p.filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p.filename = file.Name()
+	p.cwd = filepath.Dir(filename)
+	return file, nil
+}
+
+// isAbs reports whether an include pattern is absolute, in an fs.FS it's
+// relative to the root of the fs.FS
+func (p *parser) isAbs(pattern string) bool {
+	if p.fsys != nil {
+		return strings.HasPrefix(pattern, "/")
+	}
+	return filepath.IsAbs(pattern)
+}
+
+func (p *parser) join(dir, pattern string) string {
+	if p.fsys != nil {
+		return path.Join(dir, pattern)
+	}
+	return filepath.Join(dir, pattern)
+}
+
+func (p *parser) glob(pattern string) ([]string, error) {
+	if p.fsys != nil {
+		pattern = path.Clean(strings.TrimPrefix(pattern, "/"))
+		if !fs.ValidPath(pattern) {
+			return nil, &fs.PathError{Op: "glob", Path: pattern, Err: fs.ErrInvalid}
+		}
+		return fs.Glob(p.fsys, pattern)
+	}
+	return filepath.Glob(pattern)
+}
+
+// sameFile reports whether file is the open file f, files in an fs.FS are
+// compared by name
+func (p *parser) sameFile(file string, f io.Reader, name string) bool {
+	if p.fsys != nil {
+		return f != nil && file == name
+	}
+
+	of, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	st, err := of.Stat()
+	return err == nil && os.SameFile(fi, st)
+}
@@ -3,13 +3,53 @@ package config
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type format struct{}
 
+var (
+	formatsMu sync.Mutex
+	formats   = make(map[string]reflect.Value)
+)
+
+// RegisterFormat makes a format available to every Config as `format:"name"`,
+// it panics when the name is taken
+func RegisterFormat[T any](name string, fn func(string) (T, error)) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if _, ok := formats[name]; ok {
+		panic("config: format " + name + " registered twice")
+	}
+	formats[name] = reflect.ValueOf(fn)
+}
+
+// WithFormat adds a format to a Config as `format:"name"`, it's found before
+// the formats of RegisterFormat
+func WithFormat[T any](name string, fn func(string) (T, error)) Option {
+	return func(cfg *Config) error {
+		cfg.formats = withEntry(cfg.formats, name, reflect.ValueOf(fn))
+		return nil
+	}
+}
+
+// registeredFormat looks up a format of the Config, then a global one
+func (p *parser) registeredFormat(name string) (reflect.Value, bool) {
+	if fn, ok := p.formats[name]; ok {
+		return fn, true
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	fn, ok := formats[name]
+	return fn, ok
+}
+
 func (ft *format) Bytesize(s string) (int64, error) {
 	var multiplier int64 = 1
 	u := s[len(s)-1]
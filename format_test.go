@@ -0,0 +1,123 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type formatConf struct {
+	Size  int64         `format:"bytesize"`
+	Wait  time.Duration `format:"time"`
+	Port  int           `format:"test_port"`
+	Perm  string        `format:"mode"`
+	Count string        `format:"test_port"`
+}
+
+func (c *formatConf) Mode(s string) (string, error) {
+	return "method " + s, nil
+}
+
+// registerFormat registers a global format for the test only
+func registerFormat[T any](t *testing.T, name string, fn func(string) (T, error)) {
+	RegisterFormat(name, fn)
+	t.Cleanup(func() {
+		formatsMu.Lock()
+		defer formatsMu.Unlock()
+		delete(formats, name)
+	})
+}
+
+func constFormat[T any](v T) func(string) (T, error) {
+	return func(string) (T, error) { return v, nil }
+}
+
+func TestFormats(t *testing.T) {
+	got, err := LoadBytes[formatConf]([]byte("size 2k;\nwait 2min;\n"))
+	wantError(t, err, "")
+	if got.Size != 2048 || got.Wait != 2*time.Minute {
+		t.Fatalf("size %d, wait %v", got.Size, got.Wait)
+	}
+
+	_, err = LoadBytes[formatConf]([]byte("port 80;\n"))
+	wantError(t, err, "tag: fomrat:\"test_port\" in Port, func not exists in <bytes>:1")
+}
+
+func TestRegisterFormat(t *testing.T) {
+	registerFormat(t, "test_port", strconv.Atoi)
+
+	got, err := LoadBytes[formatConf]([]byte("port 80;\n"))
+	wantError(t, err, "")
+	if got.Port != 80 {
+		t.Fatalf("port = %d", got.Port)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "error", src: "port http;", err: "tag: fomrat:\"test_port\" in Port, invalid val with http, and return strconv.Atoi: parsing \"http\": invalid syntax in <bytes>:1"},
+		{name: "type", src: "count 1;", err: "tag: fomrat:\"test_port\" in Count, invalid val kind, result is int, but string required in <bytes>:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBytes[formatConf]([]byte(tt.src))
+			wantError(t, err, tt.err)
+		})
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("RegisterFormat of a taken name did not panic")
+			}
+		}()
+		RegisterFormat("test_port", strconv.Atoi)
+	}()
+}
+
+func TestWithFormat(t *testing.T) {
+	mode := WithFormat("mode", func(s string) (string, error) { return "", errors.New("not called") })
+
+	got, err := LoadBytes[formatConf]([]byte("port 80;\nperm a;\n"),
+		WithFormat("test_port", constFormat(1)), mode)
+	wantError(t, err, "")
+	// a method of the struct is found before the format of the Config
+	if got.Port != 1 || got.Perm != "method a" {
+		t.Fatalf("port %d, perm %q", got.Port, got.Perm)
+	}
+
+	// a format of a Config is not seen by another one
+	_, err = LoadBytes[formatConf]([]byte("port 80;\n"))
+	wantError(t, err, "func not exists")
+}
+
+func TestFormatOrder(t *testing.T) {
+	src := []byte("size 2k;\n")
+
+	tests := []struct {
+		name   string
+		global bool
+		opts   []Option
+		want   int64
+	}{
+		{name: "built-in", want: 2048},
+		{name: "global before built-in", global: true, want: 2},
+		{name: "config before built-in", opts: []Option{WithFormat("bytesize", constFormat[int64](3))}, want: 3},
+		{name: "config before global", global: true, opts: []Option{WithFormat("bytesize", constFormat[int64](3))}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.global {
+				registerFormat(t, "bytesize", constFormat[int64](2))
+			}
+			got, err := LoadBytes[formatConf](src, tt.opts...)
+			wantError(t, err, "")
+			if got.Size != tt.want {
+				t.Fatalf("size = %d, want %d", got.Size, tt.want)
+			}
+		})
+	}
+}
@@ -8,26 +8,70 @@ import (
 	"unicode"
 )
 
-func (cfg *Config) reset() {
-	cfg.inSearchKey()
-	cfg.inBlock = 0
-	cfg.inInclude = 0
-	cfg.currentVar = make(map[string]string)
-	cfg.searchVar = false
-	cfg.setVar = false
-	cfg.searchVarBlock = false
-	cfg.line = 1
+// reset prepares the parser for the root file
+func (p *parser) reset() {
+	p.filename = p.root
+	p.current = p.target
+	p.queue = nil
+	p.stash = nil
+	p.vars = nil
+	p.bkQueue = nil
+	p.bkMulti = false
+	p.blocks = nil
+	p.path = nil
+	p.command = nil
+	p.seen = make(map[*Configurable]*position)
+	p.local = make(map[string]*Configurable)
+	p.modules = make(map[string]bool)
+	p.via = ""
+	p.inSearchKey()
+	p.inBlock = 0
+	p.bkCond = nil
+	p.inCond = false
+	p.skipCond = false
+	p.inElse = false
+	p.condState = condNone
+	p.inProfile = false
+	p.profiles = nil
+	p.inDefine = false
+	p.inUse = false
+	p.templates = make(map[string]*template)
+	p.using = nil
+	p.uses = 0
+	p.inFor = false
+	p.loops = 0
+	p.inInclude = 0
+	p.loaded = make(map[string]bool)
+	p.includes = nil
+	p.includedFiles = 0
+	p.bytesRead = 0
+	p.currentVar = make(map[string]string)
+	p.searchVar = false
+	p.setVar = false
+	p.searchVarBlock = false
+	p.line = 1
 }
 
-func (cfg *Config) valueOf(conf interface{}) (reflect.Value, error) {
+// withEntry returns a copy of m with name set, the maps of a Config are never
+// written in place since running parses keep reading the old one
+func withEntry[V any](m map[string]V, name string, v V) map[string]V {
+	c := make(map[string]V, len(m)+1)
+	for k, old := range m {
+		c[k] = old
+	}
+	c[name] = v
+	return c
+}
+
+func (p *parser) valueOf(conf interface{}) (reflect.Value, error) {
 	rev := reflect.ValueOf(conf)
 	if rev.Type().Name() == "Value" {
 		rev = rev.Interface().(reflect.Value)
 	} else if rev.Kind() != reflect.Ptr {
-		return reflect.Value{}, cfg.error("non-pointer and can't be addr")
+		return reflect.Value{}, p.error("non-pointer and can't be addr")
 	}
 
-	if err := cfg.init(rev); err != nil {
+	if err := p.init(rev); err != nil {
 		return reflect.Value{}, err
 	}
 
@@ -36,14 +80,14 @@ func (cfg *Config) valueOf(conf interface{}) (reflect.Value, error) {
 	return rev, nil
 }
 
-func (cfg *Config) init(rev reflect.Value) error {
+func (p *parser) init(rev reflect.Value) error {
 	if rev.Kind() == reflect.Ptr {
 		rev = rev.Elem()
 	}
 
 	if rev.Type().Kind() == reflect.Slice {
 		for i := 0; i < rev.Len(); i++ {
-			if err := cfg.init(rev.Index(i)); err != nil {
+			if err := p.init(rev.Index(i)); err != nil {
 				return err
 			}
 		}
@@ -69,18 +113,18 @@ func (cfg *Config) init(rev reflect.Value) error {
 
 	if found {
 		if fn.Type().NumOut() != 1 {
-			return cfg.error("init in %s,invalid val kind, %s result required", rev.Type().String(), rev.Type().String())
+			return p.error("init in %s,invalid val kind, %s result required", rev.Type().String(), rev.Type().String())
 		}
 
 		if !fn.Type().Out(0).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-			return cfg.error("init in %s, func return invalid result, error required but return %s", rev.Type().String(), fn.Type().Out(0).String())
+			return p.error("init in %s, func return invalid result, error required but return %s", rev.Type().String(), fn.Type().Out(0).String())
 		}
 
 		result := fn.Call([]reflect.Value{})
 		if result[0].IsNil() {
 			return nil
 		} else {
-			return cfg.error("init in %s, result: %s", rev.Type().String(), result[0].Interface().(error).Error())
+			return p.error("init in %s, result: %s", rev.Type().String(), result[0].Interface().(error).Error())
 		}
 	}
 
@@ -93,18 +137,18 @@ func (cfg *Config) init(rev reflect.Value) error {
 
 		// 在配置本身上面找
 		if ref.Kind() == reflect.Struct {
-			if err := cfg.init(ref); err != nil {
+			if err := p.init(ref); err != nil {
 				return err
 			}
 		} else if ref.Kind() == reflect.Ptr && ref.Elem().Kind() == reflect.Struct {
-			if err := cfg.init(ref); err != nil {
+			if err := p.init(ref); err != nil {
 				return err
 			}
 		}
 
 		if init := field.Tag.Get("init"); init != "" {
 			// 获取函数
-			method := cfg.fixedField(init)
+			method := p.fixedField(init)
 			found := false
 			fn := rev.MethodByName(method)
 			if fn.IsValid() && fn.Kind() == reflect.Func {
@@ -114,12 +158,12 @@ func (cfg *Config) init(rev reflect.Value) error {
 			if !found && rev.CanAddr() {
 				fn = rev.Addr().MethodByName(method)
 				if !fn.IsValid() || fn.Kind() != reflect.Func {
-					return cfg.error("tag: init:\"%s\" in %s, func not exists", init, rev.Type().String())
+					return p.error("tag: init:\"%s\" in %s, func not exists", init, rev.Type().String())
 				}
 			}
 
 			if fn.Type().NumOut() != 1 {
-				return cfg.error("tag: init:\"%s\" in %s,invalid val kind, one result required, but %s return", init, field.Name, ref.Type().String(), fn.Type().NumOut())
+				return p.error("tag: init:\"%s\" in %s,invalid val kind, one result required, but %s return", init, field.Name, ref.Type().String(), fn.Type().NumOut())
 			}
 
 			result := fn.Call([]reflect.Value{})
@@ -130,23 +174,27 @@ func (cfg *Config) init(rev reflect.Value) error {
 	return nil
 }
 
-func (cfg *Config) inSearchKey() {
-	cfg.searchVal = false
-	cfg.searchKey = true
-	cfg.canSkip = true
+func (p *parser) inSearchKey() {
+	p.searchVal = false
+	p.searchKey = true
+	p.canSkip = true
 }
 
-func (cfg *Config) inSearchVal() {
-	cfg.searchKey = false
-	cfg.searchVal = true
-	cfg.canSkip = false
+func (p *parser) inSearchVal() {
+	p.searchKey = false
+	p.searchVal = true
+	p.canSkip = false
 }
 
-func (cfg *Config) delimiter(b byte) bool {
+func (p *parser) delimiter(b byte) bool {
 	return unicode.IsSpace(rune(b))
 }
 
-func (cfg *Config) clearQuoted(s string) string {
+func (p *parser) isVarByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func (p *parser) clearQuoted(s string) string {
 	s = strings.TrimSpace(s)
 	if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
 		return s[1 : len(s)-1]
@@ -155,7 +203,7 @@ func (cfg *Config) clearQuoted(s string) string {
 	return s
 }
 
-func (cfg *Config) splitQuoted(s string) ([]string, error) {
+func (p *parser) splitQuoted(s string) ([]string, error) {
 	var sq []string
 	s = strings.TrimSpace(s)
 	var last_space bool = true
@@ -198,7 +246,7 @@ func (cfg *Config) splitQuoted(s string) ([]string, error) {
 			}
 			vs.WriteByte(ch)
 		} else {
-			if need_space && cfg.delimiter(ch) {
+			if need_space && p.delimiter(ch) {
 				if vs.Len() > 0 {
 					sq = append(sq, vs.String())
 				}
@@ -226,7 +274,7 @@ func (cfg *Config) splitQuoted(s string) ([]string, error) {
 	}
 
 	if quote || s_quote || d_quote {
-		return nil, cfg.error(fmt.Sprintf("invalid value: %v", s))
+		return nil, p.error(fmt.Sprintf("invalid value: %v", s))
 	}
 
 	if vs.Len() > 0 {
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files relative to a temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// unmarshalFile unmarshals the file name of dir into v, setup configures the
+// Config first. The directory is removed from the error.
+func unmarshalFile(t *testing.T, dir, name string, v interface{}, setup ...func(cfg *Config)) error {
+	t.Helper()
+
+	cfg := New(filepath.Join(dir, name))
+	for _, fn := range setup {
+		fn(cfg)
+	}
+	if err := cfg.Unmarshal(v); err != nil {
+		return errors.New(strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), ""))
+	}
+	return nil
+}
+
+// unmarshalString unmarshals src as the file test.conf into v
+func unmarshalString(t *testing.T, src string, v interface{}, setup ...func(cfg *Config)) error {
+	t.Helper()
+
+	dir := writeFiles(t, map[string]string{"test.conf": src})
+	return unmarshalFile(t, dir, "test.conf", v, setup...)
+}
+
+// wantError fails unless err contains want, an empty want requires no error
+func wantError(t *testing.T, err error, want string) {
+	t.Helper()
+
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("error %q expected, got nil", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("error %q expected, got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Validator is implemented by configs which check themselves after a parse
+type Validator interface {
+	Validate() error
+}
+
+// Holder keeps the last valid value of a config. A reload parses into a fresh
+// value, validates it, then replaces the old one at once, so readers never
+// see a config which is partly reloaded.
+type Holder[T any] struct {
+	mu    sync.Mutex
+	cfg   *Config
+	value atomic.Pointer[T]
+
+	subMu sync.Mutex
+	subs  []*subscription
+}
+
+type subscription struct {
+	path []string
+	fn   func(changes []Change) error
+}
+
+// NewHolder parses the config into a new T
+func NewHolder[T any](cfg *Config) (*Holder[T], error) {
+	h := &Holder[T]{cfg: cfg}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Holder.Load returns the current value, it must not be modified
+func (h *Holder[T]) Load() *T {
+	return h.value.Load()
+}
+
+// Holder.Reload parses the config into a new T, the current value is kept
+// when it fails
+func (h *Holder[T]) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the value is not the one of Config.Reload, it's never changed once stored
+	v := new(T)
+	if err := h.cfg.decode(v); err != nil {
+		return err
+	}
+
+	if vd, ok := any(v).(Validator); ok {
+		if err := vd.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if old := h.value.Load(); old != nil {
+		if err := h.notify(Diff(old, v)); err != nil {
+			return err
+		}
+	}
+
+	h.value.Store(v)
+	return nil
+}
+
+// Holder.OnChange calls fn after a reload with the changes under path, like
+// "log_file" or "server.*.port", "*" matches one element and "" matches all.
+// fn runs before the new value is stored, an error vetoes the reload and the
+// callbacks already run are called again with the reverted changes.
+func (h *Holder[T]) OnChange(path string, fn func(changes []Change) error) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	h.subs = append(h.subs, &subscription{path: splitPath(path), fn: fn})
+}
+
+func (h *Holder[T]) notify(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	h.subMu.Lock()
+	subs := make([]*subscription, len(h.subs))
+	copy(subs, h.subs)
+	h.subMu.Unlock()
+
+	for i, sub := range subs {
+		matched := sub.match(changes)
+		if len(matched) == 0 {
+			continue
+		}
+
+		if err := sub.fn(matched); err != nil {
+			// roll back the callbacks which accepted the reload
+			for _, done := range subs[:i] {
+				if reverted := done.match(revertChanges(changes)); len(reverted) > 0 {
+					done.fn(reverted)
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sub *subscription) match(changes []Change) []Change {
+	var matched []Change
+	for _, c := range changes {
+		if matchPath(sub.path, splitPath(c.Path)) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// matchPath reports whether a change of path is under pattern, or contains it
+func matchPath(pattern, path []string) bool {
+	for i := 0; i < len(pattern) && i < len(path); i++ {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath splits server[1].port into server, 1, port
+func splitPath(path string) []string {
+	var elems []string
+	start := 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '.':
+			if i > start {
+				elems = append(elems, path[start:i])
+			}
+			start = i + 1
+		case '[':
+			if i > start {
+				elems = append(elems, path[start:i])
+			}
+			j := strings.IndexByte(path[i:], ']')
+			if j < 0 {
+				j = len(path) - i
+			}
+			elems = append(elems, path[i+1:i+j])
+			i += j
+			start = i + 1
+		}
+	}
+
+	if start < len(path) {
+		elems = append(elems, path[start:])
+	}
+	return elems
+}
+
+func revertChanges(changes []Change) []Change {
+	reverted := make([]Change, len(changes))
+	for i, c := range changes {
+		reverted[i] = Change{Path: c.Path, Old: c.New, New: c.Old, Kind: c.Kind}
+		switch c.Kind {
+		case Added:
+			reverted[i].Kind = Removed
+		case Removed:
+			reverted[i].Kind = Added
+		}
+	}
+	return reverted
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type holderConf struct {
+	Name    string
+	LogFile string
+	Server  []includeServer
+}
+
+func (c *holderConf) Validate() error {
+	if c.Name == "invalid" {
+		return errors.New("invalid name")
+	}
+	return nil
+}
+
+func TestHolderReload(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\nserver { listen 80; }\n"})
+	path := filepath.Join(dir, "a.conf")
+
+	cfg := New(path)
+	h, err := NewHolder[holderConf](cfg)
+	wantError(t, err, "")
+	first := h.Load()
+
+	// a reload never appends to the slices of the last value
+	for i := 0; i < 3; i++ {
+		wantError(t, h.Reload(), "")
+	}
+	if got := h.Load(); got == first || len(got.Server) != 1 || len(first.Server) != 1 {
+		t.Fatalf("got %+v, first %+v", *got, *first)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "parse error", src: "name b;\nbad;\n", err: "unknown directive"},
+		{name: "validation", src: "name invalid;\n", err: "invalid name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := h.Load()
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			wantError(t, h.Reload(), tt.err)
+			if got := h.Load(); got != before || got.Name != "a" {
+				t.Fatalf("the value changed after a failed reload: %+v", *got)
+			}
+		})
+	}
+}
+
+// TestHolderConfigReload runs Config.Reload while the value of a Holder is
+// read, go test -race reports a write to it
+func TestHolderConfigReload(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\nserver { listen 80; }\n"})
+
+	for _, unmarshal := range []bool{false, true} {
+		cfg := New(filepath.Join(dir, "a.conf"))
+		if unmarshal {
+			wantError(t, cfg.Unmarshal(&holderConf{}), "")
+		}
+		h, err := NewHolder[holderConf](cfg)
+		wantError(t, err, "")
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if n := len(h.Load().Server); n != 1 {
+					t.Errorf("server has %d elements", n)
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < 5; i++ {
+			// without Unmarshal there is nothing to reload
+			cfg.Reload()
+		}
+		for i := 0; i < 5; i++ {
+			wantError(t, h.Reload(), "")
+			cfg.Reload()
+		}
+		close(done)
+		wg.Wait()
+
+		if n := len(h.Load().Server); n != 1 {
+			t.Fatalf("server has %d elements", n)
+		}
+	}
+}
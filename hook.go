@@ -1,31 +1,330 @@
 package config
 
-import "reflect"
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 type hook struct {
-	*Config
+	*parser
 }
 
-func (hk *hook) Unique(s string) error {
-	parent, b := hk.getNearestSlice()
-	if !b {
-		return hk.error("can't not hook unique on \"%s\" directive, unique must on slice with struct", hk.typ.Name)
+var (
+	hooksMu sync.Mutex
+	hooks   = make(map[string]reflect.Value)
+)
+
+// RegisterHook makes a hook available to every Config as `hook:"name"`, it
+// panics when the name is taken
+func RegisterHook(name string, fn func(ctx *HookContext) error) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	if _, ok := hooks[name]; ok {
+		panic("config: hook " + name + " registered twice")
+	}
+	hooks[name] = reflect.ValueOf(fn)
+}
+
+// WithHook adds a hook to a Config as `hook:"name"`, it's found before the
+// hooks of RegisterHook
+func WithHook(name string, fn func(ctx *HookContext) error) Option {
+	return func(cfg *Config) error {
+		cfg.hooks = withEntry(cfg.hooks, name, reflect.ValueOf(fn))
+		return nil
+	}
+}
+
+// registeredHook looks up a hook of the Config, then a global one
+func (p *parser) registeredHook(name string) (reflect.Value, bool) {
+	if fn, ok := p.hooks[name]; ok {
+		return fn, true
+	}
+
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	fn, ok := hooks[name]
+	return fn, ok
+}
+
+// HookContext is given to hooks which take a *HookContext instead of the string
+type HookContext struct {
+	// Path is the path of the field, like server[1].port
+	Path string
+	// Field is the struct field of the directive
+	Field reflect.StructField
+	// Value is the parsed value, Raw is the value after variable substitution
+	Value reflect.Value
+	Raw   string
+	// Parent is the struct holding the field
+	Parent reflect.Value
+	// Root is the value given to Unmarshal, or the entry of Parse
+	Root reflect.Value
+	// Args are the arguments of the hook in the tag, range(1,65535) has 1 and 65535
+	Args []string
+	// File and Line are the position of the directive
+	File string
+	Line int64
+}
+
+// hookCall is a hook of a tag, like range(1,65535)
+type hookCall struct {
+	name string
+	args []string
+}
+
+// splitHooks splits a tag like "unique,file_exists,range(1,65535)"
+func (p *parser) splitHooks(tag string) ([]hookCall, error) {
+	var calls []hookCall
+	var start, open int
+	depth := 0
+	for i := 0; i <= len(tag); i++ {
+		if i < len(tag) {
+			switch tag[i] {
+			case '(':
+				if depth == 0 {
+					open = i
+				}
+				depth++
+				continue
+			case ')':
+				depth--
+				if depth < 0 {
+					return nil, p.error("tag: hook:\"%s\" in %s, unexpected \")\"", tag, p.typ.Name)
+				}
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		if depth > 0 {
+			return nil, p.error("tag: hook:\"%s\" in %s, \"(\" not closed", tag, p.typ.Name)
+		}
+
+		call := hookCall{name: strings.TrimSpace(tag[start:i])}
+		if open > start {
+			call.name = strings.TrimSpace(tag[start:open])
+			if inner := strings.TrimSpace(tag[open+1 : strings.LastIndexByte(tag[:i], ')')]); inner != "" {
+				for _, arg := range strings.Split(inner, ",") {
+					if arg = strings.TrimSpace(arg); arg != "" {
+						arg = p.clearQuoted(arg)
+					}
+					call.args = append(call.args, arg)
+				}
+			}
+		}
+		if call.name == "" {
+			return nil, p.error("tag: hook:\"%s\" in %s, empty hook", tag, p.typ.Name)
+		}
+
+		calls = append(calls, call)
+		start, open = i+1, 0
+	}
+
+	return calls, nil
+}
+
+// hookContext returns the context of the field being set
+func (p *parser) hookContext(s string, args []string) *HookContext {
+	ctx := &HookContext{
+		Path:  p.key,
+		Field: p.typ,
+		Value: p.current,
+		Raw:   s,
+		Root:  p.target,
+		Args:  args,
+		File:  p.filename,
+		Line:  p.line,
+	}
+	if len(p.path) > 0 {
+		ctx.Path = strings.Join(p.path, ".") + "." + p.key
+	}
+	if parent, ok := p.nearestStruct(); ok {
+		ctx.Parent = parent
+	}
+	if !ctx.Root.IsValid() {
+		ctx.Root = p.entry
 	}
 
-	if parent.Kind() != reflect.Slice {
-		return hk.error("unique only can be hook in struct of slice")
+	return ctx
+}
+
+// Unique rejects a value repeated in a slice, a key repeated in a map, or a
+// field repeated among the structs of a slice or a map
+func (hk *hook) Unique(ctx *HookContext) error {
+	container, ok := hk.getStruct()
+	if !ok {
+		return hk.error("can't not hook unique on \"%s\" directive, unique must on slice or map", ctx.Field.Name)
+	}
+
+	switch container.Kind() {
+	case reflect.Slice:
+		// a value of []string, the last is current
+		for _, v := range hk.siblings(container) {
+			if reflect.DeepEqual(v.Interface(), ctx.Value.Interface()) {
+				return hk.error("duplication value \"%s\" for %s", ctx.Raw, ctx.Path)
+			}
+		}
+		return nil
+	case reflect.Map:
+		// values of a map may repeat
+		if hk.inMapKey && container.MapIndex(ctx.Value).IsValid() {
+			return hk.error("duplication key \"%s\" for %s", ctx.Raw, ctx.Path)
+		}
+		return nil
+	}
+
+	parent, ok := hk.getNearestSlice()
+	if !ok || (parent.Kind() != reflect.Slice && parent.Kind() != reflect.Map) {
+		return hk.error("unique only can be hook in struct of slice or map")
 	}
 
-	// last is current
-	for i := 0; i < parent.Len()-1; i++ {
-		cur := parent.Index(i).FieldByName(hk.typ.Name)
-		if !cur.CanInterface() {
-			return hk.error("can't be interface at parent")
+	for _, v := range hk.siblings(parent) {
+		if v = reflect.Indirect(v); !v.IsValid() {
+			continue
 		}
-		if cur.Interface() == hk.current.Interface() {
-			return hk.error("duplication value \"%s\" for %s", s, hk.typ.Name)
+		if reflect.DeepEqual(v.FieldByName(ctx.Field.Name).Interface(), ctx.Value.Interface()) {
+			return hk.error("duplication value \"%s\" for %s", ctx.Raw, ctx.Path)
 		}
 	}
 
 	return nil
 }
+
+// siblings returns the elements of a slice but the last, or the values of a
+// map but the one of the block being parsed
+func (p *parser) siblings(container reflect.Value) []reflect.Value {
+	var values []reflect.Value
+	if container.Kind() == reflect.Slice {
+		for i := 0; i < container.Len()-1; i++ {
+			values = append(values, container.Index(i))
+		}
+		return values
+	}
+
+	iter := container.MapRange()
+	for iter.Next() {
+		if p.mapKey.IsValid() && reflect.DeepEqual(iter.Key().Interface(), p.mapKey.Interface()) {
+			continue
+		}
+		values = append(values, iter.Value())
+	}
+	return values
+}
+
+// checkUnique checks the fields of unique:"host,port" of the block name just
+// closed, val is the struct of the block and p.current is its slice or map
+func (p *parser) checkUnique(tag, name string, val reflect.Value) error {
+	val = reflect.Indirect(val)
+	if val.Kind() != reflect.Struct {
+		return p.error("tag: unique:\"%s\" in %s, slice or map of struct required", tag, name)
+	}
+
+	var keys []string
+	var fields []reflect.Value
+	for _, key := range strings.Split(tag, ",") {
+		key = strings.TrimSpace(key)
+		field := val.FieldByName(p.fixedField(key))
+		if !field.IsValid() {
+			return p.error("tag: unique:\"%s\" in %s, field %s not exists", tag, name, key)
+		}
+		keys = append(keys, key)
+		fields = append(fields, field)
+	}
+
+	for _, v := range p.siblings(p.current) {
+		if v = reflect.Indirect(v); !v.IsValid() {
+			continue
+		}
+
+		same := true
+		for i, field := range fields {
+			if !reflect.DeepEqual(v.FieldByName(p.fixedField(keys[i])).Interface(), field.Interface()) {
+				same = false
+				break
+			}
+		}
+		if same {
+			values := make([]string, len(keys))
+			for i, key := range keys {
+				values[i] = fmt.Sprintf("%s=%v", key, fields[i].Interface())
+			}
+			return p.error("duplication value (%s) for %s", strings.Join(values, ", "), name)
+		}
+	}
+
+	return nil
+}
+
+// FileExists checks that the value is an existing file
+func (hk *hook) FileExists(ctx *HookContext) error {
+	name := hk.clearQuoted(ctx.Raw)
+	if _, err := os.Stat(name); err != nil {
+		return hk.error("file \"%s\" of %s does not exist", name, ctx.Path)
+	}
+	return nil
+}
+
+// Range checks that a number is in range(min,max), a duration in range(1s,1m)
+func (hk *hook) Range(ctx *HookContext) error {
+	if len(ctx.Args) != 2 {
+		return hk.error("hook range of %s requires 2 arguments, range(min,max)", ctx.Path)
+	}
+
+	v := ctx.Value
+	var n, min, max float64
+	var err error
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			min, max, err = hk.durationRange(ctx.Args)
+		} else {
+			min, max, err = hk.numberRange(ctx.Args)
+		}
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min, max, err = hk.numberRange(ctx.Args)
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		min, max, err = hk.numberRange(ctx.Args)
+		n = v.Float()
+	default:
+		return hk.error("hook range of %s requires a number, %s given", ctx.Path, v.Kind())
+	}
+	if err != nil {
+		return hk.error("hook range of %s: %s", ctx.Path, err.Error())
+	}
+
+	if n < min || n > max {
+		return hk.error("%s of %s is out of range [%s, %s]", ctx.Raw, ctx.Path, ctx.Args[0], ctx.Args[1])
+	}
+	return nil
+}
+
+func (hk *hook) numberRange(args []string) (float64, float64, error) {
+	min, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := strconv.ParseFloat(args[1], 64)
+	return min, max, err
+}
+
+func (hk *hook) durationRange(args []string) (float64, float64, error) {
+	min, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := time.ParseDuration(args[1])
+	return float64(min), float64(max), err
+}
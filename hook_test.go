@@ -0,0 +1,254 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type uniqueServer struct {
+	Name string `hook:"unique"`
+	Host string
+	Port int
+}
+
+type uniqueUpstream struct {
+	Name string `hook:"unique"`
+}
+
+type uniqueConf struct {
+	Listen   []string                  `hook:"unique"`
+	Header   map[string]string         `hook:"unique"`
+	Server   []uniqueServer            `unique:"host,port"`
+	Backend  []*uniqueServer           `unique:"host, port"`
+	Upstream map[string]uniqueUpstream `unique:"name"`
+	Single   string                    `hook:"unique"`
+	Missing  []uniqueServer            `unique:"host,weight"`
+}
+
+func TestUnique(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "distinct", src: `listen a b; listen c; header a 1; header b 1; server { name a; host h; port 1; } server { name b; host h; port 2; } upstream eu { name x; } upstream us { name y; }`},
+		{name: "in one directive", src: `listen a b a;`, err: "duplication value \"a\" for listen"},
+		{name: "across directives", src: `listen a b; listen b;`, err: "duplication value \"b\" for listen"},
+		{name: "map key", src: `header a 1; header a 2;`, err: "duplication key \"a\" for header"},
+		{name: "map values may repeat", src: `header a 1; header b 1;`},
+		{name: "field of slice", src: `server { name a; } server { name a; }`, err: "duplication value \"a\" for server[1].name"},
+		{name: "field of map", src: `upstream eu { name a; } upstream us { name a; }`, err: "duplication value \"a\" for upstream[us].name"},
+		{name: "same map key", src: `upstream eu { name a; } upstream eu { name b; }`},
+		{name: "composite", src: `server { name a; host h; port 1; } server { name b; host h; port 1; }`, err: "duplication value (host=h, port=1) for server"},
+		{name: "composite of pointers", src: `backend { host h; port 1; } backend { host h; port 1; }`, err: "duplication value (host=h, port=1) for backend"},
+		{name: "composite of map", src: `upstream eu { name a; } upstream us { name a; }`, err: "duplication value"},
+		{name: "composite unknown field", src: `missing { host h; }`, err: "tag: unique:\"host,weight\" in missing, field weight not exists"},
+		{name: "not in slice", src: `single a;`, err: "unique only can be hook in struct of slice or map"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBytes[uniqueConf]([]byte(tt.src))
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+type uniqueTags struct {
+	Tags [][]string `hook:"unique"`
+}
+
+// values of a slice or a map type are compared without a panic
+func TestUniqueDeepEqual(t *testing.T) {
+	c := &uniqueTags{Tags: [][]string{{"a"}, {"a"}}}
+	p := New("").newParser()
+	p.current = reflect.ValueOf(c).Elem().Field(0)
+	p.queue = []reflect.Value{p.current}
+	hk := &hook{p}
+
+	ctx := &HookContext{Field: reflect.TypeOf(c).Elem().Field(0), Value: p.current.Index(1), Raw: "a", Path: "tags"}
+	wantError(t, hk.Unique(ctx), "duplication value \"a\" for tags")
+
+	c.Tags[1] = []string{"b"}
+	wantError(t, hk.Unique(ctx), "")
+}
+
+type rangeServer struct {
+	Port int `hook:"range(1,65535),even"`
+}
+
+type rangeConf struct {
+	Timeout time.Duration  `hook:"range(1s,1m)"`
+	Weight  float64        `hook:"range(0,1)"`
+	Ports   map[string]int `hook:"range(1,10)"`
+	Workers []uint         `hook:"range(1,4)"`
+	Server  []rangeServer
+	Name    string            `hook:"range(1,2)"`
+	Labels  map[string]string `hook:"lower"`
+
+	calls []string
+}
+
+func (c *rangeConf) Lower(ctx *HookContext) error {
+	c.calls = append(c.calls, ctx.Raw)
+	if s := ctx.Value.String(); s != strings.ToLower(s) {
+		return fmt.Errorf("%s of %s is not lower case", s, ctx.Path)
+	}
+	return nil
+}
+
+func (s *rangeServer) Even(ctx *HookContext) error {
+	root := ctx.Root.Addr().Interface().(*rangeConf)
+	root.calls = append(root.calls, fmt.Sprintf("%s %s %v", ctx.Path, ctx.Raw, ctx.Parent.Addr().Interface() == s))
+	if ctx.Value.Int()%2 != 0 {
+		return fmt.Errorf("%s must be even in %s:%d", ctx.Path, filepath.Base(ctx.File), ctx.Line)
+	}
+	return nil
+}
+
+func TestHookRange(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "within", src: `timeout 30s; weight 0.5; ports http 1; ports https 10; workers 1 4; server { port 80; }`},
+		{name: "duration", src: `timeout 2m;`, err: "2m of timeout is out of range [1s, 1m]"},
+		{name: "float", src: `weight 1.5;`, err: "1.5 of weight is out of range [0, 1]"},
+		{name: "map value", src: `ports http 1; ports https 11;`, err: "11 of ports is out of range [1, 10]"},
+		{name: "slice element", src: `workers 1 5;`, err: "5 of workers is out of range [1, 4]"},
+		{name: "chained", src: `server { port 80; } server { port 81; }`, err: "server[1].port must be even in test.conf:1"},
+		{name: "chained in order", src: `server { port 70000; }`, err: "70000 of server[0].port is out of range"},
+		{name: "not a number", src: `name a;`, err: "hook range of name requires a number, string given"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantError(t, unmarshalString(t, tt.src, &rangeConf{}), tt.err)
+		})
+	}
+}
+
+func TestHookElements(t *testing.T) {
+	// hooks of a map run for each value, not for the keys
+	got := &rangeConf{}
+	err := unmarshalString(t, "labels Env prod;\nlabels Tier web;\nserver { port 80; }\nserver { port 82; }\n", got)
+	wantError(t, err, "")
+	want := []string{"prod", "web", "server[0].port 80 true", "server[1].port 82 true"}
+	if !reflect.DeepEqual(got.calls, want) {
+		t.Fatalf("calls = %q, want %q", got.calls, want)
+	}
+
+	wantError(t, unmarshalString(t, "labels env Prod;\n", &rangeConf{}), "Prod of labels is not lower case")
+}
+
+type registryConf struct {
+	Port  int    `hook:"range(1,10)"`
+	Name  string `hook:"test_name(x)"`
+	Label string `hook:"lower"`
+}
+
+func (c *registryConf) Lower(ctx *HookContext) error {
+	return nil
+}
+
+// registerHook registers a global hook for the test only
+func registerHook(t *testing.T, name string, fn func(ctx *HookContext) error) {
+	RegisterHook(name, fn)
+	t.Cleanup(func() {
+		hooksMu.Lock()
+		defer hooksMu.Unlock()
+		delete(hooks, name)
+	})
+}
+
+// recordHook returns a hook appending who to calls
+func recordHook(calls *[]string, who string) func(ctx *HookContext) error {
+	return func(ctx *HookContext) error {
+		*calls = append(*calls, fmt.Sprintf("%s %s %s %q", who, ctx.Path, ctx.Raw, ctx.Args))
+		return nil
+	}
+}
+
+func TestRegisterHook(t *testing.T) {
+	var calls []string
+	registerHook(t, "test_name", recordHook(&calls, "global"))
+
+	_, err := LoadBytes[registryConf]([]byte("name a;\n"))
+	wantError(t, err, "")
+	if len(calls) != 1 || calls[0] != `global name a ["x"]` {
+		t.Fatalf("calls = %q", calls)
+	}
+
+	registerHook(t, "test_fail", func(ctx *HookContext) error { return errors.New("rejected") })
+	_, err = LoadBytes[struct {
+		Name string `hook:"test_fail"`
+	}]([]byte("name a;\n"))
+	wantError(t, err, "rejected")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("RegisterHook of a taken name did not panic")
+			}
+		}()
+		RegisterHook("test_name", recordHook(&calls, "again"))
+	}()
+}
+
+func TestWithHook(t *testing.T) {
+	var calls []string
+	_, err := LoadBytes[registryConf]([]byte("name a;\nlabel B;\n"),
+		WithHook("test_name", recordHook(&calls, "config")),
+		WithHook("lower", recordHook(&calls, "config")))
+	wantError(t, err, "")
+	// a method of the struct is found before the hook of the Config
+	if len(calls) != 1 || calls[0] != `config name a ["x"]` {
+		t.Fatalf("calls = %q", calls)
+	}
+
+	// a hook of a Config is not seen by another one
+	_, err = LoadBytes[registryConf]([]byte("name a;\n"))
+	wantError(t, err, "tag: hook:\"test_name\" in Name, func not exists")
+}
+
+func TestHookOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		global bool
+		config bool
+		want   string
+	}{
+		{name: "built-in", want: "11 of port is out of range [1, 10]"},
+		{name: "global before built-in", global: true, want: "global"},
+		{name: "config before built-in", config: true, want: "config"},
+		{name: "config before global", global: true, config: true, want: "config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var opts []Option
+			if tt.global {
+				registerHook(t, "range", recordHook(&calls, "global"))
+			}
+			if tt.config {
+				opts = append(opts, WithHook("range", recordHook(&calls, "config")))
+			}
+
+			_, err := LoadBytes[registryConf]([]byte("port 11;\n"), opts...)
+			if !tt.global && !tt.config {
+				wantError(t, err, tt.want)
+				return
+			}
+			wantError(t, err, "")
+			if want := tt.want + ` port 11 ["1" "10"]`; len(calls) != 1 || calls[0] != want {
+				t.Fatalf("calls = %q, want %q", calls, want)
+			}
+		})
+	}
+}
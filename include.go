@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxInclude is the default limit of nested includes
+const DefaultMaxInclude = 100
+
+const (
+	includeNormal = iota
+	includeOptional
+	includeOnce
+)
+
+var includeModes = map[string]int{
+	"include":          includeNormal,
+	"include_optional": includeOptional,
+	"include_once":     includeOnce,
+}
+
+// Include records an include of the last parse
+type Include struct {
+	// Pattern is the path given to include
+	Pattern string
+	// Dir is the directory which satisfied a relative pattern
+	Dir string
+	// Files are the files matched by the pattern
+	Files []string
+	// File and Line are the position of the include
+	File string
+	Line int64
+
+	globs []string
+}
+
+// include parses the files matched by pattern in the current block
+func (p *parser) include(pattern string, mode int) error {
+	files, dir, err := p.resolveInclude(pattern)
+	if err != nil {
+		return p.error(err.Error())
+	}
+
+	p.includes = append(p.includes, Include{
+		Pattern: pattern,
+		Dir:     dir,
+		Files:   files,
+		File:    p.filename,
+		Line:    p.line,
+		globs:   p.includeGlobs(pattern),
+	})
+
+	if len(files) == 0 {
+		// without strict includes only a glob may match nothing, as before
+		if mode == includeNormal && (p.strictInclude || !hasMeta(pattern)) {
+			return p.error("include \"%s\" matched no files", pattern)
+		}
+		return nil
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if mode == includeOnce && p.loaded[file] {
+			continue
+		}
+
+		if err := p.allowInclude(file); err != nil {
+			return err
+		}
+
+		chain := p.includeChain()
+		if p.maxInclude > 0 && len(chain) > p.maxInclude {
+			return p.error("too many nested includes, exceeds %d limit", p.maxInclude)
+		}
+		if p.included(file) {
+			chain = append(chain, p.relPath(file))
+			return p.error("include cycle detected: %s", strings.Join(chain, " -> "))
+		}
+
+		p.pushStash()
+		p.filename = file
+		if err := p.parse(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveInclude globs a relative pattern in the directory of the including
+// file, then in the include paths, the first directory with matches is used
+func (p *parser) resolveInclude(pattern string) ([]string, string, error) {
+	if p.isAbs(pattern) {
+		files, err := p.glob(pattern)
+		return files, "", err
+	}
+
+	for _, dir := range p.includeDirs() {
+		files, err := p.glob(p.join(dir, pattern))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(files) > 0 {
+			return files, dir, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// allowInclude checks file against the allowed roots and the limit of files
+func (p *parser) allowInclude(file string) error {
+	p.includedFiles++
+	if p.maxFiles > 0 && p.includedFiles > p.maxFiles {
+		return p.error("too many included files, exceeds %d limit", p.maxFiles)
+	}
+
+	// an fs.FS can't be escaped
+	if len(p.includeRoots) == 0 || p.fsys != nil {
+		return nil
+	}
+
+	real, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return p.error(err.Error())
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return p.error(err.Error())
+	}
+
+	for _, root := range p.includeRoots {
+		rel, err := filepath.Rel(root, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+
+	return p.error("include \"%s\" is not allowed, %s is outside of the allowed roots", file, real)
+}
+
+// countReader counts the bytes read by the parser
+type countReader struct {
+	r io.Reader
+	p *parser
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.p.bytesRead += int64(n)
+	if cr.p.maxBytes > 0 && cr.p.bytesRead > cr.p.maxBytes {
+		return n, fmt.Errorf("too many bytes read, exceeds %d limit", cr.p.maxBytes)
+	}
+	return n, err
+}
+
+// includeGlobs returns the absolute patterns which may satisfy an include
+func (p *parser) includeGlobs(pattern string) []string {
+	if p.isAbs(pattern) {
+		return []string{pattern}
+	}
+
+	var globs []string
+	for _, dir := range p.includeDirs() {
+		globs = append(globs, p.join(dir, pattern))
+	}
+	return globs
+}
+
+// includeDirs returns the directories searched for relative includes
+func (p *parser) includeDirs() []string {
+	if p.fsys != nil {
+		return append([]string{p.cwd}, p.fsIncludePaths...)
+	}
+	return append([]string{p.cwd}, p.includePaths...)
+}
+
+// includeChain returns the open files from the root file, each with the line
+// of its include
+func (p *parser) includeChain() []string {
+	var chain []string
+	for i, st := range p.stash {
+		next := p.file
+		if i+1 < len(p.stash) {
+			next = p.stash[i+1].file
+		}
+		if st.file != next {
+			chain = append(chain, fmt.Sprintf("%s:%d", p.relPath(st.filename), st.line))
+		}
+	}
+
+	return append(chain, fmt.Sprintf("%s:%d", p.relPath(p.filename), p.line))
+}
+
+// included reports whether file is one of the open files
+func (p *parser) included(file string) bool {
+	if p.sameFile(file, p.file, p.filename) {
+		return true
+	}
+	for _, st := range p.stash {
+		if p.sameFile(file, st.file, st.filename) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relPath returns path relative to the directory of the root file if it can
+func (p *parser) relPath(path string) string {
+	root := p.cwd
+	if len(p.stash) > 0 {
+		root = p.stash[0].cwd
+	}
+
+	if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,49 @@
+package config
+
+import "testing"
+
+func TestIncludeInBlock(t *testing.T) {
+	got, err := loadInclude(t, map[string]string{
+		"a.conf":      "server { listen x; }\nserver {\n    set $p 90;\n    include common.conf;\n    listen z;\n}\nname top;\n",
+		"common.conf": "port $p;\nname common;\n",
+	}, nil)
+	wantError(t, err, "")
+
+	want := []includeServer{{Listen: "x"}, {Listen: "z", Name: "common", Port: "90"}}
+	if len(got.Server) != len(want) {
+		t.Fatalf("server = %+v", got.Server)
+	}
+	for i := range want {
+		if got.Server[i] != want[i] {
+			t.Fatalf("server[%d] = %+v, want %+v", i, got.Server[i], want[i])
+		}
+	}
+	if got.Name != "top" {
+		t.Fatalf("name = %q, want top", got.Name)
+	}
+}
+
+func TestIncludeInBlockErrors(t *testing.T) {
+	inBlock := "server {\n    include common.conf;\n    listen z;\n}\n"
+	tests := []struct {
+		name   string
+		root   string
+		common string
+		err    string
+	}{
+		{name: "stray brace", root: inBlock, common: "name common;\n}\n", err: "unexpected \"}\" in "},
+		{name: "stray brace at top level", root: "include common.conf;\n", common: "}\n", err: "unexpected \"}\" in "},
+		{name: "block not closed", root: "include common.conf;\n", common: "server {\n", err: "block not closed by \"}\""},
+		{name: "position", root: inBlock, common: "name common;\nbad;\n", err: "common.conf:2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadInclude(t, map[string]string{
+				"a.conf":      tt.root,
+				"common.conf": tt.common,
+			}, nil)
+			wantError(t, err, tt.err)
+		})
+	}
+}
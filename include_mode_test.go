@@ -0,0 +1,74 @@
+package config
+
+import "testing"
+
+func TestIncludeModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		setup func(cfg *Config)
+		zone  []string
+		err   string
+	}{
+		{
+			name:  "sorted glob",
+			files: map[string]string{"a.conf": "include conf.d/*.conf;\n", "conf.d/b.conf": "zone b;\n", "conf.d/a.conf": "zone a;\n", "conf.d/c.conf": "zone c;\n"},
+			zone:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "strict empty glob",
+			files: map[string]string{"a.conf": "include conf.d/*.conf;\n"},
+			err:   "include \"conf.d/*.conf\" matched no files",
+		},
+		{
+			name:  "strict missing file",
+			files: map[string]string{"a.conf": "include b.conf;\n"},
+			err:   "include \"b.conf\" matched no files",
+		},
+		{
+			name:  "not strict empty glob",
+			files: map[string]string{"a.conf": "include conf.d/*.conf;\nzone a;\n"},
+			setup: func(cfg *Config) { cfg.StrictInclude(false) },
+			zone:  []string{"a"},
+		},
+		{
+			name:  "not strict missing file",
+			files: map[string]string{"a.conf": "include b.conf;\n"},
+			setup: func(cfg *Config) { cfg.StrictInclude(false) },
+			err:   "include \"b.conf\" matched no files",
+		},
+		{
+			name:  "optional",
+			files: map[string]string{"a.conf": "include_optional conf.d/*.conf;\ninclude_optional b.conf;\nzone a;\n"},
+			zone:  []string{"a"},
+		},
+		{
+			name:  "once",
+			files: map[string]string{"a.conf": "include b.conf;\ninclude_once b.conf;\ninclude_once c.conf;\ninclude_once c.conf;\n", "b.conf": "zone b;\n", "c.conf": "zone c;\n"},
+			zone:  []string{"b", "c"},
+		},
+		{
+			name:  "once missing file",
+			files: map[string]string{"a.conf": "include_once b.conf;\n"},
+			zone:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadInclude(t, tt.files, tt.setup)
+			wantError(t, err, tt.err)
+			if err != nil {
+				return
+			}
+			if len(got.Zone) != len(tt.zone) {
+				t.Fatalf("zone = %q, want %q", got.Zone, tt.zone)
+			}
+			for i := range tt.zone {
+				if got.Zone[i] != tt.zone[i] {
+					t.Fatalf("zone = %q, want %q", got.Zone, tt.zone)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncludePaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app/a.conf":                "include x.conf;\ninclude y.conf;\ninclude conf.d/*.conf;\n",
+		"app/x.conf":                "zone app-x;\n",
+		"shared1/x.conf":            "zone shared1-x;\n",
+		"shared1/y.conf":            "zone shared1-y;\ninclude x.conf;\n",
+		"shared2/y.conf":            "zone shared2-y;\n",
+		"shared2/conf.d/z.conf":     "zone shared2-z;\n",
+		"shared2/conf.d/other.conf": "zone shared2-other;\n",
+	})
+	app := filepath.Join(dir, "app")
+	shared1 := filepath.Join(dir, "shared1")
+	shared2 := filepath.Join(dir, "shared2")
+
+	var cfg *Config
+	got := &includeConf{}
+	err := unmarshalFile(t, app, "a.conf", got, func(c *Config) {
+		cfg = c
+		if err := c.IncludePaths(shared1, shared2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	wantError(t, err, "")
+
+	// the directory of the including file is searched first, then the
+	// include paths in order
+	wantZone := []string{"app-x", "shared1-y", "shared1-x", "shared2-other", "shared2-z"}
+	if len(got.Zone) != len(wantZone) {
+		t.Fatalf("zone = %q, want %q", got.Zone, wantZone)
+	}
+	for i := range wantZone {
+		if got.Zone[i] != wantZone[i] {
+			t.Fatalf("zone = %q, want %q", got.Zone, wantZone)
+		}
+	}
+
+	wantDir := map[string]string{
+		"a.conf:1": app,
+		"a.conf:2": shared1,
+		"y.conf:2": shared1,
+		"a.conf:3": shared2,
+	}
+	includes := cfg.Includes()
+	if len(includes) != len(wantDir) {
+		t.Fatalf("includes = %+v", includes)
+	}
+	for _, inc := range includes {
+		pos := fmt.Sprintf("%s:%d", filepath.Base(inc.File), inc.Line)
+		if inc.Dir != wantDir[pos] {
+			t.Fatalf("dir of the include of %s in %s = %q, want %q", inc.Pattern, pos, inc.Dir, wantDir[pos])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncludeRoots(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app/a.conf":        "zone a;\n",
+		"app/conf.d/b.conf": "zone b;\n",
+		"secret.conf":       "zone secret;\n",
+	})
+	app := filepath.Join(dir, "app")
+	if err := os.Symlink(filepath.Join(dir, "secret.conf"), filepath.Join(app, "link.conf")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink(filepath.Join(app, "conf.d", "b.conf"), filepath.Join(app, "inner.conf")); err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		err     string
+	}{
+		{name: "inside", include: "conf.d/*.conf"},
+		{name: "symlink inside", include: "inner.conf"},
+		{name: "parent", include: "../secret.conf", err: "is outside of the allowed roots"},
+		{name: "absolute", include: filepath.Join(dir, "secret.conf"), err: "is outside of the allowed roots"},
+		{name: "symlink", include: "link.conf", err: "is outside of the allowed roots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(app, "main.conf"), []byte("include "+tt.include+";\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := unmarshalFile(t, app, "main.conf", &includeConf{}, func(cfg *Config) {
+				if err := cfg.AllowIncludeRoots(app); err != nil {
+					t.Fatal(err)
+				}
+			})
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestIncludeLimits(t *testing.T) {
+	files := map[string]string{
+		"a.conf":        "zone a;\ninclude conf.d/*.conf;\n",
+		"conf.d/b.conf": "zone b;\n",
+		"conf.d/c.conf": "zone c;\n",
+	}
+
+	tests := []struct {
+		name  string
+		setup func(cfg *Config)
+		err   string
+	}{
+		{name: "unlimited"},
+		{name: "files", setup: func(cfg *Config) { cfg.MaxIncludeFiles(1) }, err: "too many included files, exceeds 1 limit"},
+		{name: "files within limit", setup: func(cfg *Config) { cfg.MaxIncludeFiles(2) }},
+		{name: "bytes", setup: func(cfg *Config) { cfg.MaxBytes(40) }, err: "too many bytes read, exceeds 40 limit"},
+		{name: "bytes of the root file", setup: func(cfg *Config) { cfg.MaxBytes(10) }, err: "too many bytes read, exceeds 10 limit"},
+		{name: "bytes within limit", setup: func(cfg *Config) { cfg.MaxBytes(64) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadInclude(t, files, tt.setup)
+			wantError(t, err, tt.err)
+		})
+	}
+}
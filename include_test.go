@@ -0,0 +1,106 @@
+package config
+
+import "testing"
+
+type includeServer struct {
+	Listen string
+	Name   string
+	Port   string
+}
+
+type includeConf struct {
+	Name   string
+	Zone   []string
+	Server []includeServer
+}
+
+// loadInclude writes files and decodes a.conf, setup may be nil
+func loadInclude(t *testing.T, files map[string]string, setup func(cfg *Config)) (*includeConf, error) {
+	t.Helper()
+
+	got := &includeConf{}
+	err := unmarshalFile(t, writeFiles(t, files), "a.conf", got, func(cfg *Config) {
+		if setup != nil {
+			setup(cfg)
+		}
+	})
+	return got, err
+}
+
+func TestIncludeCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		setup func(cfg *Config)
+		err   string
+	}{
+		{
+			name: "chain",
+			files: map[string]string{
+				"a.conf": "name a;\nzone a;\ninclude b.conf;\n",
+				"b.conf": "zone b;\ninclude a.conf;\n",
+			},
+			err: "include cycle detected: a.conf:3 -> b.conf:2 -> a.conf in",
+		},
+		{
+			name: "self by glob",
+			files: map[string]string{
+				"a.conf": "include *.conf;\n",
+			},
+			err: "include cycle detected: a.conf:1 -> a.conf",
+		},
+		{
+			name: "included twice is not a cycle",
+			files: map[string]string{
+				"a.conf": "include b.conf;\ninclude c.conf;\ninclude c.conf;\n",
+				"b.conf": "include c.conf;\n",
+				"c.conf": "zone c;\n",
+			},
+		},
+		{
+			name: "depth",
+			files: map[string]string{
+				"a.conf": "include b.conf;\n",
+				"b.conf": "include c.conf;\n",
+				"c.conf": "include d.conf;\n",
+				"d.conf": "zone d;\n",
+			},
+			setup: func(cfg *Config) { cfg.MaxIncludeDepth(2) },
+			err:   "too many nested includes, exceeds 2 limit",
+		},
+		{
+			name: "within depth",
+			files: map[string]string{
+				"a.conf": "include b.conf;\n",
+				"b.conf": "include c.conf;\n",
+				"c.conf": "zone c;\n",
+			},
+			setup: func(cfg *Config) { cfg.MaxIncludeDepth(2) },
+		},
+		{
+			name: "unlimited depth",
+			files: map[string]string{
+				"a.conf": "include b.conf;\n",
+				"b.conf": "include c.conf;\n",
+				"c.conf": "zone c;\n",
+			},
+			setup: func(cfg *Config) { cfg.MaxIncludeDepth(0) },
+		},
+		{
+			name: "cycle with unlimited depth",
+			files: map[string]string{
+				"a.conf": "include b.conf;\n",
+				"b.conf": "include a.conf;\n",
+			},
+			setup: func(cfg *Config) { cfg.MaxIncludeDepth(0) },
+			err:   "include cycle detected: a.conf:1 -> b.conf:1 -> a.conf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadInclude(t, tt.files, tt.setup)
+			wantError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import "io/fs"
+
+// Load unmarshals the file into a new T
+func Load[T any](filename string, opts ...Option) (*T, error) {
+	return unmarshal[T](New(filename, opts...))
+}
+
+// MustLoad is like Load but panics on errors
+func MustLoad[T any](filename string, opts ...Option) *T {
+	v, err := Load[T](filename, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// LoadFS unmarshals the file of fsys into a new T, includes are read from fsys too
+func LoadFS[T any](fsys fs.FS, filename string, opts ...Option) (*T, error) {
+	// opts may have room after it, it's not the slice of the caller to append to
+	all := make([]Option, 0, len(opts)+1)
+	all = append(all, opts...)
+	all = append(all, WithFS(fsys))
+	return unmarshal[T](New(filename, all...))
+}
+
+// LoadBytes unmarshals data into a new T, relative includes are resolved
+// against the working directory, or the root of the fs.FS of WithFS
+func LoadBytes[T any](data []byte, opts ...Option) (*T, error) {
+	if data == nil {
+		data = []byte{}
+	}
+
+	cfg := New(bytesName, opts...)
+	cfg.data = data
+	return unmarshal[T](cfg)
+}
+
+func unmarshal[T any](cfg *Config) (*T, error) {
+	v := new(T)
+	if err := cfg.Unmarshal(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
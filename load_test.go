@@ -0,0 +1,96 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/a.conf":        {Data: []byte("name a;\ninclude conf.d/*.conf;\nserver {\n    include server.conf;\n}\n")},
+		"etc/conf.d/b.conf": {Data: []byte("zone b;\n")},
+		"etc/conf.d/c.conf": {Data: []byte("zone c;\n")},
+		"etc/server.conf":   {Data: []byte("listen 80;\ninclude /shared/port.conf;\n")},
+		"shared/port.conf":  {Data: []byte("port 8080;\n")},
+		"secret.conf":       {Data: []byte("name secret;\n")},
+	}
+
+	got, err := LoadFS[includeConf](fsys, "etc/a.conf")
+	wantError(t, err, "")
+	if got.Name != "a" || len(got.Zone) != 2 || got.Zone[0] != "b" || got.Zone[1] != "c" {
+		t.Fatalf("got %+v", *got)
+	}
+	if len(got.Server) != 1 || got.Server[0] != (includeServer{Listen: "80", Port: "8080"}) {
+		t.Fatalf("server = %+v", got.Server)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "parent in fsys", src: "include ../secret.conf;\n"},
+		{name: "out of fsys", src: "include ../../secret.conf;\n", err: "glob ../secret.conf: invalid argument in etc/main.conf:1"},
+		{name: "glob out of fsys", src: "include ../../*.conf;\n", err: "invalid argument"},
+		{name: "missing", src: "include none.conf;\n", err: "include \"none.conf\" matched no files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys["etc/main.conf"] = &fstest.MapFile{Data: []byte(tt.src)}
+			_, err := LoadFS[includeConf](fsys, "etc/main.conf")
+			wantError(t, err, tt.err)
+		})
+	}
+
+	// WithFS does the same for New
+	v := &includeConf{}
+	wantError(t, New("/etc/a.conf", WithFS(fsys)).Unmarshal(v), "")
+	if v.Name != "a" || len(v.Server) != 1 {
+		t.Fatalf("got %+v", *v)
+	}
+}
+
+func TestLoadFSOptions(t *testing.T) {
+	a := fstest.MapFS{"a.conf": {Data: []byte("name a;\n")}}
+	b := fstest.MapFS{"a.conf": {Data: []byte("name b;\n")}}
+
+	// LoadFS never appends to the options of the caller
+	opts := make([]Option, 1, 2)
+	opts[0] = WithVariable("x", "1")
+	for _, want := range []string{"a", "b", "a"} {
+		fsys := a
+		if want == "b" {
+			fsys = b
+		}
+		got, err := LoadFS[includeConf](fsys, "a.conf", opts...)
+		wantError(t, err, "")
+		if got.Name != want {
+			t.Fatalf("name = %q, want %q", got.Name, want)
+		}
+		if extra := opts[:2][1]; extra != nil {
+			t.Fatal("LoadFS wrote to the options of the caller")
+		}
+	}
+}
+
+func TestMustLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.conf":   "name $x;\n",
+		"bad.conf": "bad;\n",
+	})
+
+	got := MustLoad[includeConf](filepath.Join(dir, "a.conf"), WithVariable("x", "a"))
+	if got.Name != "a" {
+		t.Fatalf("name = %q, want a", got.Name)
+	}
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok {
+			t.Fatal("MustLoad did not panic")
+		}
+		wantError(t, err, "unknown directive")
+	}()
+	MustLoad[includeConf](filepath.Join(dir, "bad.conf"))
+}
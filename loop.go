@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxLoop is the default limit of total iterations of for loops
+const DefaultMaxLoop = 10000
+
+// startFor is called with the "{" after "for $name in ...", the body is
+// parsed once for each value
+func (p *parser) startFor(s string, reader *bufio.Reader) error {
+	p.inFor = false
+	p.inSearchKey()
+
+	line := p.line
+	s = strings.TrimSpace(s)
+	sf := strings.Fields(s)
+	if len(sf) < 3 || sf[1] != "in" {
+		return p.error("invalid \"for %s\", \"for $name in values\" required", strings.TrimSpace(s))
+	}
+
+	name := strings.TrimPrefix(sf[0], "$")
+	for i := 0; i < len(name); i++ {
+		if !p.isVarByte(name[i]) {
+			name = ""
+			break
+		}
+	}
+	if name == "" {
+		return p.error("invalid variable \"%s\" in \"for\"", sf[0])
+	}
+
+	s = strings.TrimSpace(s[len(sf[0]):])
+	values, err := p.loopValues(strings.TrimSpace(s[len("in"):]))
+	if err != nil {
+		return err
+	}
+
+	body, err := p.captureBlock(reader)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		via := fmt.Sprintf("for $%s = %s", name, v)
+		if p.via != "" {
+			via += ", " + p.via
+		}
+
+		if err := p.splice(body, p.filename, line, map[string]string{name: v}, via); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loopValues returns the values of a loop, "a b c" or a range "0..7"
+func (p *parser) loopValues(s string) ([]string, error) {
+	values, err := p.splitQuoted(p.expand(s))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) == 1 && strings.Contains(values[0], "..") {
+		bounds := strings.SplitN(values[0], "..", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, p.error("invalid range \"%s\" in \"for\"", values[0])
+		}
+		to, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, p.error("invalid range \"%s\" in \"for\"", values[0])
+		}
+
+		// the span of a range like 0..9223372036854775807 overflows int
+		step := 1
+		span := uint64(to) - uint64(from)
+		if from > to {
+			step = -1
+			span = uint64(from) - uint64(to)
+		}
+		n := span + 1
+		if n == 0 {
+			n = math.MaxUint64
+		}
+		if err := p.countLoop(n); err != nil {
+			return nil, err
+		}
+		values = make([]string, 0, n)
+		for i := from; uint64(len(values)) < n; i += step {
+			values = append(values, strconv.Itoa(i))
+		}
+
+		return values, nil
+	}
+
+	if err := p.countLoop(uint64(len(values))); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// countLoop counts n more iterations, before any value is made
+func (p *parser) countLoop(n uint64) error {
+	left := p.maxLoop - p.loops
+	if left < 0 {
+		left = 0
+	}
+	if n > uint64(left) {
+		return p.error("too many loop iterations, exceeds %d limit", p.maxLoop)
+	}
+	p.loops += int(n)
+	return nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import "testing"
+
+type loopWorker struct {
+	Id   int
+	Zone string
+}
+
+type loopConf struct {
+	Name   string
+	Zone   []string
+	Worker []loopWorker
+}
+
+func TestLoop(t *testing.T) {
+	src := `
+for $i in 0..3 {
+    worker { id $i; }
+}
+for $z in a b c {
+    zone $z;
+}
+for $i in 2..1 {
+    for $z in x y {
+        worker { id $i; zone $z; }
+    }
+}
+name $i;
+`
+	got := &loopConf{}
+	err := unmarshalString(t, src, got)
+	wantError(t, err, "")
+
+	want := []loopWorker{{Id: 0}, {Id: 1}, {Id: 2}, {Id: 3}, {2, "x"}, {2, "y"}, {1, "x"}, {1, "y"}}
+	if len(got.Worker) != len(want) {
+		t.Fatalf("worker = %+v", got.Worker)
+	}
+	for i := range want {
+		if got.Worker[i] != want[i] {
+			t.Fatalf("worker[%d] = %+v, want %+v", i, got.Worker[i], want[i])
+		}
+	}
+	if len(got.Zone) != 3 || got.Zone[0] != "a" || got.Zone[2] != "c" {
+		t.Fatalf("zone = %q", got.Zone)
+	}
+
+	// the loop variable is scoped to the body
+	if got.Name != "$i" {
+		t.Fatalf("name = %q, want $i", got.Name)
+	}
+}
+
+func TestLoopLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		max  int
+		err  string
+	}{
+		{name: "within limit", src: `for $i in 1..5 { zone $i; }`, max: 5},
+		{name: "range", src: `for $i in 0..5 { zone $i; }`, max: 5, err: "too many loop iterations, exceeds 5 limit"},
+		{name: "values", src: `for $i in a b c d e f { zone $i; }`, max: 5, err: "too many loop iterations, exceeds 5 limit"},
+		{name: "total of loops", src: `for $i in 1..3 { zone $i; } for $i in 1..3 { zone $i; }`, max: 5, err: "too many loop iterations"},
+		{name: "nested", src: `for $i in 1..2 { for $j in 1..3 { zone $j; } }`, max: 7, err: "too many loop iterations"},
+		{name: "huge range", src: `for $i in 0..2000000000 { zone $i; }`, err: "exceeds 10000 limit"},
+		{name: "range of int64", src: `for $i in 0..9223372036854775807 { zone $i; }`, err: "exceeds 10000 limit"},
+		{name: "reversed range of int64", src: `for $i in 9223372036854775807..-9223372036854775808 { zone $i; }`, err: "exceeds 10000 limit"},
+		{name: "whole int64", src: `for $i in -9223372036854775808..9223372036854775807 { zone $i; }`, err: "exceeds 10000 limit"},
+		{name: "end of int64", src: `for $i in 9223372036854775805..9223372036854775807 { zone $i; }`, max: 3},
+		{name: "empty values", src: `set v ""; for $i in $v { zone $i; } for $i in 1..5 { zone $i; }`, max: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unmarshalString(t, tt.src, &loopConf{}, func(cfg *Config) {
+				if tt.max > 0 {
+					cfg.MaxLoop(tt.max)
+				}
+			})
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "syntax", src: `for $i 1..2 { }`, err: "\"for $name in values\" required"},
+		{name: "variable", src: `for $a-b in 1..2 { }`, err: "invalid variable \"$a-b\" in \"for\""},
+		{name: "range", src: `for $i in 1..x { }`, err: "invalid range \"1..x\" in \"for\""},
+		{name: "without block", src: `for $i in 1..2;`, err: "\"for\" requires a block"},
+		{name: "position", src: "for $i in 1..2 {\n    worker { id x$i; }\n}\n", err: "in test.conf:2 (for $i = 1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unmarshalString(t, tt.src, &loopConf{})
+			wantError(t, err, tt.err)
+		})
+	}
+}
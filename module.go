@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Module contributes directives from another package, it's registered in
+// the init of its package:
+//
+//	func init() { config.RegisterModule(&cacheModule{}) }
+type Module interface {
+	Name() string
+	// Directives are registered when the module is loaded
+	Directives() []DirectiveSpec
+	// Init is called after the directives are registered
+	Init(ctx *ModuleContext) error
+}
+
+// DirectiveSpec describes a directive of a Module, one of Value, Handle and
+// HandleBlock is set as given to Directive, HandleFunc and HandleBlock
+type DirectiveSpec struct {
+	Name        string
+	Value       interface{}
+	Handle      HandlerFunc
+	HandleBlock BlockHandlerFunc
+	Options     []DirectiveOption
+}
+
+// ModuleContext is given to Module.Init
+type ModuleContext struct {
+	Config *Config
+	// File and Line are the position of load_module, empty when the module is
+	// loaded by Config.LoadModule
+	File string
+	Line int64
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   = make(map[string]Module)
+)
+
+// RegisterModule makes a module available to every Config, it panics when
+// the name is taken
+func RegisterModule(m Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+
+	if _, ok := modules[m.Name()]; ok {
+		panic("config: module " + m.Name() + " registered twice")
+	}
+	modules[m.Name()] = m
+}
+
+// Config.RegisterModule makes a module available to this Config, it's found
+// before the modules of RegisterModule
+func (cfg *Config) RegisterModule(m Module) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	if _, ok := cfg.modules[m.Name()]; ok {
+		return cfg.error("module [ %s ] duplication", m.Name())
+	}
+	cfg.modules[m.Name()] = m
+	return nil
+}
+
+// Config.LoadModule registers the directives of a module and initializes it
+func (cfg *Config) LoadModule(name string) error {
+	m, ok := cfg.module(name)
+	if !ok {
+		return cfg.error("unknown module [ %s ]", name)
+	}
+
+	cfg.Lock()
+	loaded := cfg.loaded[name]
+	cfg.Unlock()
+	if loaded {
+		return cfg.error("module [ %s ] already loaded", name)
+	}
+
+	// a module is loaded with all its directives or not at all
+	specs := m.Directives()
+	configs := make([]*Configurable, 0, len(specs))
+	for _, spec := range specs {
+		config, err := cfg.configurable(spec)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, config)
+	}
+	if err := cfg.registerAll(configs); err != nil {
+		return err
+	}
+
+	cfg.Lock()
+	cfg.loaded[name] = true
+	cfg.Unlock()
+
+	return m.Init(&ModuleContext{Config: cfg})
+}
+
+// Config.LoadModuleDirective add the load_module directive, "load_module name;"
+// loads a module at the top level for the rest of the parse
+func (cfg *Config) LoadModuleDirective() error {
+	_, err := cfg.HandleFunc("load_module", func(ctx *DirectiveContext, args []string) error {
+		return ctx.p.loadModule(ctx, args[0])
+	}, Take1, Contexts("main"))
+	return err
+}
+
+func (cfg *Config) module(name string) (Module, bool) {
+	cfg.Lock()
+	m, ok := cfg.modules[name]
+	cfg.Unlock()
+	if ok {
+		return m, true
+	}
+
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	m, ok = modules[name]
+	return m, ok
+}
+
+// configurable returns the directive of spec
+func (cfg *Config) configurable(spec DirectiveSpec) (*Configurable, error) {
+	config := &Configurable{name: spec.Name}
+	for _, opt := range spec.Options {
+		opt.applyDirective(config)
+	}
+
+	switch {
+	case spec.Handle != nil:
+		if config.arity&TakeBlock != 0 {
+			return nil, cfg.error("directive [ %s ] takes a block, use HandleBlock", spec.Name)
+		}
+		if config.arity == 0 {
+			config.arity = AnyArgs
+		}
+		config.handle = spec.Handle
+	case spec.HandleBlock != nil:
+		config.arity |= TakeBlock
+		config.handleBlock = spec.HandleBlock
+	case spec.Value != nil:
+		rev, err := cfg.newParser().valueOf(spec.Value)
+		if err != nil {
+			return nil, err
+		}
+		config.config = rev
+		if len(config.contexts) == 0 {
+			config.contexts = []string{"main"}
+		}
+	default:
+		return nil, cfg.error("directive [ %s ] has no value or handler", spec.Name)
+	}
+
+	return config, nil
+}
+
+// loadModule loads a module for the rest of the parse, its directives are
+// not registered on the Config
+func (p *parser) loadModule(ctx *DirectiveContext, name string) error {
+	m, ok := p.cfg.module(name)
+	if !ok {
+		return fmt.Errorf("unknown module \"%s\"", name)
+	}
+
+	p.cfg.Lock()
+	loaded := p.cfg.loaded[name]
+	p.cfg.Unlock()
+	if loaded || p.modules[name] {
+		return fmt.Errorf("module \"%s\" is already loaded", name)
+	}
+
+	local := make(map[string]*Configurable)
+	for _, spec := range m.Directives() {
+		config, err := p.cfg.configurable(spec)
+		if err != nil {
+			return err
+		}
+
+		field := p.fixedField(spec.Name)
+		if _, ok := p.directive(field); ok || local[field] != nil {
+			return fmt.Errorf("directive \"%s\" of module \"%s\" is already registered", spec.Name, name)
+		}
+		local[field] = config
+	}
+	for field, config := range local {
+		p.local[field] = config
+	}
+	p.modules[name] = true
+
+	return m.Init(&ModuleContext{Config: p.cfg, File: ctx.File, Line: ctx.Line})
+}
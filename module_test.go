@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testModule struct {
+	name  string
+	specs []DirectiveSpec
+	err   error
+	inits []ModuleContext
+}
+
+func (m *testModule) Name() string                { return m.name }
+func (m *testModule) Directives() []DirectiveSpec { return m.specs }
+
+func (m *testModule) Init(ctx *ModuleContext) error {
+	m.inits = append(m.inits, *ctx)
+	return m.err
+}
+
+// cacheModule returns a module with a handler and a value, size gets the
+// argument of cache_size
+func cacheModule(name string, size *[]string, zone *string) *testModule {
+	return &testModule{
+		name: name,
+		specs: []DirectiveSpec{
+			{Name: "cache_size", Handle: func(ctx *DirectiveContext, args []string) error {
+				*size = append(*size, args[0])
+				return nil
+			}, Options: []DirectiveOption{Take1}},
+			{Name: "cache_zone", Value: zone},
+		},
+	}
+}
+
+func TestLoadModule(t *testing.T) {
+	var size []string
+	var zone string
+	m := cacheModule("cache", &size, &zone)
+
+	var cfg *Config
+	err := unmarshalString(t, "cache_size 10m;\ncache_zone a;\n", &arityConf{}, func(c *Config) {
+		cfg = c
+		wantError(t, c.RegisterModule(m), "")
+		wantError(t, c.RegisterModule(m), "module [ cache ] duplication")
+		wantError(t, c.LoadModule("none"), "unknown module [ none ]")
+		wantError(t, c.LoadModule("cache"), "")
+		wantError(t, c.LoadModule("cache"), "module [ cache ] already loaded")
+	})
+	wantError(t, err, "")
+
+	if len(size) != 1 || size[0] != "10m" || zone != "a" {
+		t.Fatalf("size %q, zone %q", size, zone)
+	}
+	if len(m.inits) != 1 || m.inits[0].Config != cfg || m.inits[0].File != "" {
+		t.Fatalf("inits = %+v", m.inits)
+	}
+	if n := len(cfg.Directives()); n != 2 {
+		t.Fatalf("%d directives, want 2", n)
+	}
+}
+
+func TestLoadModuleRollback(t *testing.T) {
+	handle := func(ctx *DirectiveContext, args []string) error { return nil }
+
+	tests := []struct {
+		name  string
+		specs []DirectiveSpec
+		err   string
+	}{
+		{
+			name:  "invalid spec",
+			specs: []DirectiveSpec{{Name: "a", Handle: handle}, {Name: "b"}},
+			err:   "directive [ b ] has no value or handler",
+		},
+		{
+			name:  "registered directive",
+			specs: []DirectiveSpec{{Name: "a", Handle: handle}, {Name: "taken", Handle: handle}},
+			err:   "directive [ Taken ] duplication",
+		},
+		{
+			name:  "twice in the module",
+			specs: []DirectiveSpec{{Name: "a", Handle: handle}, {Name: "a", Handle: handle}},
+			err:   "directive [ A ] duplication",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := New("test.conf")
+			_, err := cfg.HandleFunc("taken", handle)
+			wantError(t, err, "")
+			m := &testModule{name: "broken", specs: tt.specs}
+			wantError(t, cfg.RegisterModule(m), "")
+
+			wantError(t, cfg.LoadModule("broken"), tt.err)
+			if infos := cfg.Directives(); len(infos) != 1 || infos[0].Name != "taken" {
+				t.Fatalf("directives after a failed load = %+v", infos)
+			}
+			if len(m.inits) != 0 {
+				t.Fatal("Init called after a failed load")
+			}
+
+			// nothing is left to prevent loading it once fixed
+			m.specs = []DirectiveSpec{{Name: "a", Handle: handle}}
+			wantError(t, cfg.LoadModule("broken"), "")
+		})
+	}
+}
+
+// globalModules counts the runs of TestRegisterModule, a global module can't
+// be registered twice with go test -count
+var globalModules int
+
+func TestRegisterModule(t *testing.T) {
+	globalModules++
+	name := fmt.Sprintf("test_global_cache_%d", globalModules)
+
+	var globalSize, localSize []string
+	var zone string
+	RegisterModule(cacheModule(name, &globalSize, &zone))
+
+	// the module of a Config is found before the global one
+	err := unmarshalString(t, "cache_size 1m;\n", &arityConf{}, func(cfg *Config) {
+		wantError(t, cfg.RegisterModule(cacheModule(name, &localSize, &zone)), "")
+		wantError(t, cfg.LoadModule(name), "")
+	})
+	wantError(t, err, "")
+	if len(localSize) != 1 || len(globalSize) != 0 {
+		t.Fatalf("local %q, global %q", localSize, globalSize)
+	}
+
+	err = unmarshalString(t, "cache_size 2m;\n", &arityConf{}, func(cfg *Config) {
+		wantError(t, cfg.LoadModule(name), "")
+	})
+	wantError(t, err, "")
+	if len(globalSize) != 1 || globalSize[0] != "2m" {
+		t.Fatalf("global %q", globalSize)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("RegisterModule of a taken name did not panic")
+			}
+		}()
+		RegisterModule(&testModule{name: name})
+	}()
+}
+
+func TestLoadModuleDirective(t *testing.T) {
+	var size []string
+	var zone string
+	m := cacheModule("cache", &size, &zone)
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "load", src: "name a;\nload_module cache;\ncache_size 1m;\ncache_zone z;\n"},
+		{name: "before load", src: "cache_size 1m;\nload_module cache;\n", err: "unknown directive"},
+		{name: "twice", src: "load_module cache;\nload_module cache;\n", err: "module \"cache\" is already loaded in test.conf:2"},
+		{name: "unknown", src: "load_module none;\n", err: "unknown module \"none\" in test.conf:1"},
+		{name: "in a block", src: "server {\n    load_module cache;\n}\n", err: "\"load_module\" directive is not allowed here in test.conf:2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, zone, m.inits = nil, "", nil
+
+			var cfg *Config
+			err := unmarshalString(t, tt.src, &arityConf{}, func(c *Config) {
+				cfg = c
+				wantError(t, c.RegisterModule(m), "")
+				wantError(t, c.LoadModuleDirective(), "")
+			})
+			wantError(t, err, tt.err)
+			if err != nil {
+				return
+			}
+
+			if len(size) != 1 || zone != "z" || len(m.inits) != 1 || m.inits[0].Line != 2 {
+				t.Fatalf("size %q, zone %q, inits %+v", size, zone, m.inits)
+			}
+
+			// the directives are not kept by the Config, the next parse
+			// loads the module again
+			if n := len(cfg.Directives()); n != 1 {
+				t.Fatalf("%d directives, want load_module only", n)
+			}
+			wantError(t, cfg.Reload(), "")
+			if len(size) != 2 || len(m.inits) != 2 {
+				t.Fatalf("size %q, inits %+v after reload", size, m.inits)
+			}
+		})
+	}
+}
+
+func TestLoadModuleInitError(t *testing.T) {
+	m := &testModule{name: "failing", err: errors.New("no cache dir")}
+	err := unmarshalString(t, "name a;\nload_module failing;\n", &arityConf{}, func(cfg *Config) {
+		wantError(t, cfg.RegisterModule(m), "")
+		wantError(t, cfg.LoadModuleDirective(), "")
+	})
+	wantError(t, err, "no cache dir in test.conf:2")
+}
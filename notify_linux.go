@@ -0,0 +1,71 @@
+//go:build linux
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify wakes the watcher up on any event in the watched directories
+type inotify struct {
+	file   *os.File
+	fd     int
+	dirs   map[string]bool
+	events chan struct{}
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &inotify{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		dirs:   make(map[string]bool),
+		events: make(chan struct{}, 1),
+	}
+	go in.read()
+
+	return in, nil
+}
+
+func (in *inotify) Add(dir string) error {
+	if in.dirs[dir] {
+		return nil
+	}
+
+	if _, err := syscall.InotifyAddWatch(in.fd, dir, inotifyMask); err != nil {
+		return err
+	}
+	in.dirs[dir] = true
+	return nil
+}
+
+func (in *inotify) Events() <-chan struct{} {
+	return in.events
+}
+
+func (in *inotify) Close() error {
+	return in.file.Close()
+}
+
+func (in *inotify) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := in.file.Read(buf); err != nil {
+			return
+		}
+
+		select {
+		case in.events <- struct{}{}:
+		default:
+		}
+	}
+}
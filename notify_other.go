@@ -0,0 +1,9 @@
+//go:build !linux
+
+package config
+
+import "errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("inotify is not available")
+}
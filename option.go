@@ -0,0 +1,151 @@
+package config
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Option sets an option of a Config, it's given to New or Load
+type Option func(cfg *Config) error
+
+// apply sets options, the first error is returned
+func (cfg *Config) apply(opts ...Option) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WithAutoCamel auto replace _ to camel, default true
+func WithAutoCamel(b bool) Option {
+	return func(cfg *Config) error {
+		cfg.camel = b
+		return nil
+	}
+}
+
+// WithVariable inject a variable, it can be used as $name and tested by if
+func WithVariable(name, value string) Option {
+	return func(cfg *Config) error {
+		cfg.variables = withEntry(cfg.variables, name, value)
+		return nil
+	}
+}
+
+// WithProfile select the active profile, CONFIG_PROFILE is used if it's not set
+func WithProfile(name string) Option {
+	return func(cfg *Config) error {
+		cfg.profile = name
+		return nil
+	}
+}
+
+// WithMaxLoop set the limit of total iterations of for loops in one parse
+func WithMaxLoop(n int) Option {
+	return func(cfg *Config) error {
+		cfg.maxLoop = n
+		return nil
+	}
+}
+
+// WithMaxUse set the limit of total template uses in one parse
+func WithMaxUse(n int) Option {
+	return func(cfg *Config) error {
+		cfg.maxUse = n
+		return nil
+	}
+}
+
+// WithMaxIncludeDepth set the limit of nested includes, 0 is unlimited like the
+// other limits of includes, cycles are still detected
+func WithMaxIncludeDepth(n int) Option {
+	return func(cfg *Config) error {
+		cfg.maxInclude = n
+		return nil
+	}
+}
+
+// WithStrictInclude set whether an include matching no file is an error, default
+// true. With false a glob matching nothing is ignored like before, a missing
+// file is still an error.
+func WithStrictInclude(b bool) Option {
+	return func(cfg *Config) error {
+		cfg.strictInclude = b
+		return nil
+	}
+}
+
+// WithIncludePaths set directories searched in order for relative includes,
+// after the directory of the including file, with WithFS they are in the fs.FS
+func WithIncludePaths(dirs ...string) Option {
+	return func(cfg *Config) error {
+		cfg.fsIncludePaths = make([]string, 0, len(dirs))
+		for _, dir := range dirs {
+			cfg.fsIncludePaths = append(cfg.fsIncludePaths, path.Clean(strings.TrimPrefix(dir, "/")))
+		}
+
+		paths := make([]string, 0, len(dirs))
+		for _, dir := range dirs {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return cfg.error(err.Error())
+			}
+			paths = append(paths, abs)
+		}
+		cfg.includePaths = paths
+		return nil
+	}
+}
+
+// WithIncludeRoots refuse included files out of roots, symlinks are resolved
+func WithIncludeRoots(roots ...string) Option {
+	return func(cfg *Config) error {
+		paths := make([]string, 0, len(roots))
+		for _, root := range roots {
+			real, err := filepath.EvalSymlinks(root)
+			if err != nil {
+				return cfg.error(err.Error())
+			}
+			if real, err = filepath.Abs(real); err != nil {
+				return cfg.error(err.Error())
+			}
+			paths = append(paths, real)
+		}
+		cfg.includeRoots = paths
+		return nil
+	}
+}
+
+// WithMaxIncludeFiles set the limit of included files in one parse, 0 is unlimited
+func WithMaxIncludeFiles(n int) Option {
+	return func(cfg *Config) error {
+		cfg.maxFiles = n
+		return nil
+	}
+}
+
+// WithMaxBytes set the limit of bytes read in one parse, 0 is unlimited
+func WithMaxBytes(n int64) Option {
+	return func(cfg *Config) error {
+		cfg.maxBytes = n
+		return nil
+	}
+}
+
+// WithFS read the file and its includes from fsys instead of the disk, paths
+// are slash separated and relative to the root of fsys, which is the only
+// root includes are allowed in
+func WithFS(fsys fs.FS) Option {
+	return func(cfg *Config) error {
+		cfg.fsys = fsys
+		return nil
+	}
+}
@@ -0,0 +1,456 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// parser holds the state of one parse, it works on a copy of the options of
+// its Config, so a Config can run several parses at once
+type parser struct {
+	options
+	cfg            *Config
+	root           string
+	filename       string
+	via            string
+	queue          []reflect.Value
+	current        reflect.Value
+	target         reflect.Value
+	entry          reflect.Value
+	typ            reflect.StructField
+	searchVal      bool
+	searchKey      bool
+	inBlock        int
+	inInclude      int
+	includeMode    int
+	loaded         map[string]bool
+	includes       []Include
+	includedFiles  int
+	bytesRead      int64
+	canSkip        bool
+	skip           bool
+	bkQueue        []bool
+	bkMulti        bool
+	bkCond         []int
+	inCond         bool
+	skipCond       bool
+	inElse         bool
+	condState      int
+	inProfile      bool
+	profiles       []*profile
+	inDefine       bool
+	inUse          bool
+	templates      map[string]*template
+	using          []string
+	uses           int
+	inFor          bool
+	loops          int
+	mapKey         reflect.Value
+	inMapKey       bool
+	key            string
+	blocks         []string
+	path           []string
+	uniques        []string
+	setVar         bool
+	searchVar      bool
+	searchVarBlock bool
+	vars           []map[string]string
+	currentVar     map[string]string
+	seen           map[*Configurable]*position
+	local          map[string]*Configurable
+	modules        map[string]bool
+	command        *Configurable
+	cwd            string
+	data           []byte
+	file           io.Reader
+	line           int64
+	stash          []*stash
+	format         *format
+	hook           *hook
+}
+
+// newParser returns a parser with a copy of the options of cfg
+func (cfg *Config) newParser() *parser {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	p := &parser{
+		options: cfg.options,
+		cfg:     cfg,
+		root:    cfg.filename,
+		entry:   cfg.entry,
+		format:  &format{},
+	}
+	p.hook = &hook{p}
+	p.filename = p.root
+	p.data = cfg.data
+
+	return p
+}
+
+// load parses the file into target, then merges the active profile over it
+func (p *parser) load(target reflect.Value) error {
+	p.cfg.Lock()
+	err := p.cfg.err
+	if err == nil {
+		p.cfg.running++
+	}
+	p.cfg.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		p.publish()
+		p.cfg.Lock()
+		p.cfg.running--
+		p.cfg.Unlock()
+	}()
+
+	p.target = target
+	p.reset()
+
+	if err := p.parse(); err != nil {
+		return err
+	}
+
+	return p.applyProfiles()
+}
+
+// keyword handles the built-in directives, it returns false for the others
+func (p *parser) keyword(s *bytes.Buffer) (bool, error) {
+	word := s.String()
+	if p.inElse && word != "if" {
+		return false, p.error("\"else\" must be followed by \"if\" or a block")
+	}
+
+	switch word {
+	case "include", "include_optional", "include_once":
+		p.inSearchVal()
+		p.inInclude++
+		p.includeMode = includeModes[word]
+	case "set":
+		p.inSearchVal()
+		p.setVar = true
+	case "if":
+		if p.inElse {
+			// else if: skip it when a branch has been taken
+			p.inElse = false
+			p.skipCond = p.condState == condTaken
+		} else {
+			p.condState = condNone
+		}
+		p.inSearchVal()
+		p.inCond = true
+	case "profile":
+		if !p.topLevel() {
+			return false, p.error("\"profile\" directive is only allowed at the top level")
+		}
+		p.inSearchVal()
+		p.inProfile = true
+	case "define":
+		p.inSearchVal()
+		p.inDefine = true
+	case "use":
+		p.inSearchVal()
+		p.inUse = true
+	case "for":
+		p.inSearchVal()
+		p.inFor = true
+	case "else":
+		if p.condState == condNone {
+			return false, p.error("\"else\" without \"if\"")
+		}
+		p.inElse = true
+	default:
+		p.condState = condNone
+		return false, nil
+	}
+
+	if word != "if" && word != "else" {
+		p.condState = condNone
+	}
+	s.Reset()
+	return true, nil
+}
+
+func (p *parser) parse() error {
+	file, err := p.openFile()
+	if err != nil {
+		return p.error(err.Error())
+	}
+	defer func() {
+		file.Close()
+	}()
+
+	p.loaded[p.filename] = true
+	p.file = file
+
+	p.line = 1
+
+	return p.parseReader(bufio.NewReader(&countReader{r: p.file, p: p}))
+}
+
+func (p *parser) parseReader(reader *bufio.Reader) error {
+	var err error
+	var s bytes.Buffer
+	var b byte
+	for {
+		b, err = reader.ReadByte()
+		if err == io.EOF {
+			if !p.searchKey {
+				return p.error("invalid config vaild")
+			}
+
+			if s.Len() > 0 {
+				return p.error("\"%s\" directive is not allowed here", s.String())
+			}
+
+			if p.inElse {
+				return p.error("\"else\" must be followed by \"if\" or a block")
+			}
+
+			if err := p.popStash(); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return p.error(err.Error())
+		}
+
+		// bug in \r
+		if b == '\n' {
+			p.line++
+		}
+
+		if p.canSkip && b == '#' {
+			reader.ReadLine()
+			p.line++
+			continue
+		}
+		if p.canSkip && b == '/' {
+			if p.skip {
+				reader.ReadLine()
+				p.line++
+				p.skip = false
+				continue
+			}
+			p.skip = true
+			continue
+		}
+		if p.searchKey {
+			if b == ';' {
+				// blocks kept as they are, handlers and unmarshalers take
+				// directives without arguments
+				if s.Len() > 0 && (p.inTree(blockType) || p.isHandler(s.String()) || p.isUnmarshaler(s.String())) {
+					if err := p.bareDirective(s.String()); err != nil {
+						return err
+					}
+					s.Reset()
+					continue
+				}
+				return p.error("unknown directive \"" + s.String() + "\"")
+			}
+
+			if b == '}' && p.inBlock == 0 {
+				return p.error("unexpected \"}\"")
+			}
+
+			if p.delimiter(b) {
+				if s.Len() > 0 {
+					if ok, err := p.keyword(&s); err != nil {
+						return err
+					} else if ok {
+						continue
+					}
+
+					p.inSearchVal()
+					if err := p.getElement(s.String()); err != nil {
+						return err
+					}
+					s.Reset()
+				}
+				continue
+			}
+
+			// if(
+			if b == '(' && s.String() == "if" {
+				if _, err := p.keyword(&s); err != nil {
+					return err
+				}
+			}
+		}
+
+		if p.inCond {
+			if b == '{' && p.condClosed(s.String()) {
+				if err := p.startCond(s.String(), reader); err != nil {
+					return err
+				}
+				s.Reset()
+				continue
+			}
+
+			if b == ';' {
+				return p.error("\"if\" requires a block")
+			}
+			s.WriteByte(b)
+			continue
+		}
+
+		if p.searchVal {
+			// ${name} may hold '{' and '}', keep them away from blocks
+			if p.searchVarBlock {
+				if b == ';' || b == '\n' {
+					return p.error("variable is not terminated by \"}\"")
+				}
+				if b == '}' {
+					p.searchVarBlock = false
+				}
+				s.WriteByte(b)
+				continue
+			}
+
+			if p.searchVar {
+				p.searchVar = false
+				if b == '{' {
+					p.searchVarBlock = true
+					s.WriteByte(b)
+					continue
+				}
+			}
+
+			if b == '$' {
+				// set $name value; the name stays literal
+				if p.setVar && len(bytes.TrimSpace(s.Bytes())) == 0 {
+					continue
+				}
+				p.searchVar = true
+			}
+		}
+
+		if b == '{' {
+			// else{
+			if p.searchKey && s.String() == "else" {
+				if _, err := p.keyword(&s); err != nil {
+					return err
+				}
+			}
+
+			if p.inProfile {
+				if err := p.startProfile(s.String(), reader); err != nil {
+					return err
+				}
+				s.Reset()
+				continue
+			}
+
+			if p.inDefine {
+				if err := p.startDefine(s.String(), reader); err != nil {
+					return err
+				}
+				s.Reset()
+				continue
+			}
+
+			if p.inFor {
+				if err := p.startFor(s.String(), reader); err != nil {
+					return err
+				}
+				s.Reset()
+				continue
+			}
+
+			if p.inElse && s.Len() == 0 {
+				if err := p.startElse(reader); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := p.createBlock(&s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if p.searchKey && b == '}' && p.inBlock > 0 {
+			if err := p.closeBlock(&s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if p.searchVal && b == ';' {
+			if s.Len() < 1 {
+				return p.error("unknown value of  directive \"" + s.String() + "\"")
+			}
+			if p.inProfile {
+				return p.error("\"profile\" requires a block")
+			}
+
+			if p.inDefine {
+				return p.error("\"define\" requires a block")
+			}
+
+			if p.inFor {
+				return p.error("\"for\" requires a block")
+			}
+
+			if p.inUse {
+				p.inUse = false
+				p.inSearchKey()
+				args := s.String()
+				s.Reset()
+				if err := p.use(args); err != nil {
+					return err
+				}
+				continue
+			}
+
+			//  copy to p.current
+			p.inSearchKey()
+
+			// set to map
+			if p.setVar {
+				sf := strings.Fields(s.String())
+				if len(sf) != 2 {
+					return p.error("set map with %s invalid", s.String())
+				}
+				p.currentVar[sf[0]] = p.expand(sf[1])
+				p.setVar = false
+				s.Reset()
+				continue
+			}
+
+			if p.inInclude > 0 {
+				pattern := strings.TrimSpace(p.expand(s.String()))
+				s.Reset()
+				p.inInclude--
+				if err := p.include(pattern, p.includeMode); err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := p.set(p.expand(s.String()))
+			if err != nil {
+				return err
+			}
+
+			s.Reset()
+			p.popElement()
+			continue
+		}
+
+		s.WriteByte(b)
+	}
+
+	if !p.searchKey && p.inBlock > 0 {
+		return p.error("invalid config file")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+// ProfileEnv is the environment variable which selects the active profile
+// when Config.Profile is not called
+const ProfileEnv = "CONFIG_PROFILE"
+
+type profile struct {
+	name     string
+	body     []byte
+	filename string
+	cwd      string
+	line     int64
+}
+
+func (p *parser) activeProfile() string {
+	if p.profile != "" {
+		return p.profile
+	}
+	return os.Getenv(ProfileEnv)
+}
+
+// startProfile is called with the "{" after profile name, the body of the
+// active profile is kept until the whole file is parsed
+func (p *parser) startProfile(s string, reader *bufio.Reader) error {
+	p.inProfile = false
+	p.inSearchKey()
+
+	name := strings.TrimSpace(p.expand(s))
+	if name == "" || len(strings.Fields(name)) != 1 {
+		return p.error("invalid profile name \"%s\"", name)
+	}
+
+	pf := &profile{name: name, filename: p.filename, cwd: p.cwd, line: p.line}
+	body, err := p.captureBlock(reader)
+	if err != nil {
+		return err
+	}
+
+	if name == p.activeProfile() {
+		pf.body = body
+		p.profiles = append(p.profiles, pf)
+	}
+
+	return nil
+}
+
+// applyProfiles parses the bodies of the active profile as top level directives
+func (p *parser) applyProfiles() error {
+	profiles := p.profiles
+	p.profiles = nil
+
+	filename, cwd := p.filename, p.cwd
+	for _, pf := range profiles {
+		p.filename = pf.filename
+		p.cwd = pf.cwd
+		p.line = pf.line
+		if err := p.parseReader(bufio.NewReader(bytes.NewReader(pf.body))); err != nil {
+			return err
+		}
+	}
+	p.filename, p.cwd = filename, cwd
+
+	return nil
+}
+
+// topLevel reports whether the parser is out of any block, includes count
+func (p *parser) topLevel() bool {
+	if p.inBlock > 0 {
+		return false
+	}
+
+	for _, s := range p.stash {
+		if s.inBlock > 0 {
+			return false
+		}
+	}
+
+	return true
+}
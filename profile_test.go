@@ -0,0 +1,88 @@
+package config
+
+import "testing"
+
+type profileConf struct {
+	LogFile string
+	Level   string
+	Server  []condServer
+}
+
+func TestProfile(t *testing.T) {
+	src := `
+profile production {
+    log_file /var/log/prod.log;
+    server { listen 443; }
+}
+log_file run/log/file.log;
+level info;
+server { listen 80; }
+profile production {
+    level warn;
+}
+profile staging {
+    log_file /var/log/staging.log;
+    level debug;
+}
+`
+	tests := []struct {
+		name    string
+		profile string
+		env     string
+		want    profileConf
+	}{
+		{name: "none", want: profileConf{LogFile: "run/log/file.log", Level: "info"}},
+		{name: "production", profile: "production", want: profileConf{LogFile: "/var/log/prod.log", Level: "warn"}},
+		{name: "staging", profile: "staging", want: profileConf{LogFile: "/var/log/staging.log", Level: "debug"}},
+		{name: "environment", env: "staging", want: profileConf{LogFile: "/var/log/staging.log", Level: "debug"}},
+		{name: "option before environment", profile: "production", env: "staging", want: profileConf{LogFile: "/var/log/prod.log", Level: "warn"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ProfileEnv, tt.env)
+
+			got := &profileConf{}
+			err := unmarshalString(t, src, got, func(cfg *Config) {
+				if tt.profile != "" {
+					cfg.Profile(tt.profile)
+				}
+			})
+			wantError(t, err, "")
+			if got.LogFile != tt.want.LogFile || got.Level != tt.want.Level {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+
+			// blocks of the profile are added after the base ones
+			wantServers := 1
+			if tt.profile == "production" {
+				wantServers = 2
+			}
+			if len(got.Server) != wantServers || got.Server[0].Listen != "80" {
+				t.Fatalf("server = %+v", got.Server)
+			}
+		})
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "in block", src: `server { profile production { level warn; } }`, err: "\"profile\" directive is only allowed at the top level"},
+		{name: "without block", src: `profile production;`, err: "\"profile\" requires a block"},
+		{name: "invalid name", src: `profile a b { level warn; }`, err: "invalid profile name \"a b\""},
+		{name: "error line", src: "log_file a;\nprofile production {\n    level;\n}\n", err: "in test.conf:3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unmarshalString(t, tt.src, &profileConf{}, func(cfg *Config) {
+				cfg.Profile("production")
+			})
+			wantError(t, err, tt.err)
+		})
+	}
+}
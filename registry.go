@@ -0,0 +1,144 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// DirectiveInfo describes a directive added by Directive, HandleFunc or HandleBlock
+type DirectiveInfo struct {
+	Name string
+	// Type is the type of the value given to Directive, or of the handler
+	Type     reflect.Type
+	Arity    Arity
+	Contexts []string
+	// Seen reports whether the last parse used the directive, File and Line
+	// are where it's used first
+	Seen bool
+	File string
+	Line int64
+}
+
+type position struct {
+	file string
+	line int64
+}
+
+// Config.Directives returns the registered directives sorted by name
+func (cfg *Config) Directives() []DirectiveInfo {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	infos := make([]DirectiveInfo, 0, len(cfg.directives))
+	for _, c := range cfg.directives {
+		info := DirectiveInfo{
+			Name:     c.name,
+			Arity:    c.arity,
+			Contexts: append([]string(nil), c.contexts...),
+		}
+
+		switch {
+		case c.handle != nil:
+			info.Type = reflect.TypeOf(c.handle)
+		case c.handleBlock != nil:
+			info.Type = reflect.TypeOf(c.handleBlock)
+		default:
+			info.Type = c.config.Type()
+		}
+
+		if c.seen != nil {
+			info.Seen = true
+			info.File = c.seen.file
+			info.Line = c.seen.line
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Config.RemoveDirective remove a directive, parses running keep it until they
+// look it up again
+func (cfg *Config) RemoveDirective(directive string) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	name := cfg.fixedField(directive)
+	if _, ok := cfg.directives[name]; !ok {
+		return cfg.error("directive [ %s ] not found", directive)
+	}
+	delete(cfg.directives, name)
+
+	return nil
+}
+
+// register adds a directive. A directive registered while parsing, e.g. by a
+// handler loading a plugin, is used by the rest of the parse, and can be
+// registered again by the next parse.
+func (cfg *Config) register(config *Configurable) (*Configurable, error) {
+	if err := cfg.registerAll([]*Configurable{config}); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// registerAll adds all the directives or none of them
+func (cfg *Config) registerAll(configs []*Configurable) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	names := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		name := cfg.fixedField(config.name)
+		if old, ok := cfg.directives[name]; (ok && !old.dynamic) || names[name] {
+			return cfg.error("directive [ %s ] duplication", name)
+		}
+		names[name] = true
+	}
+
+	for _, config := range configs {
+		config.dynamic = cfg.running > 0
+		cfg.directives[cfg.fixedField(config.name)] = config
+	}
+	return nil
+}
+
+// directive looks up a directive of a module loaded by this parse, then a
+// registered one
+func (p *parser) directive(s string) (*Configurable, bool) {
+	if c, ok := p.local[s]; ok {
+		return c, true
+	}
+
+	p.cfg.Lock()
+	defer p.cfg.Unlock()
+
+	c, ok := p.cfg.directives[s]
+	return c, ok
+}
+
+// markSeen records the first use of a directive in this parse
+func (p *parser) markSeen(c *Configurable) {
+	if _, ok := p.seen[c]; !ok {
+		p.seen[c] = &position{file: p.filename, line: p.line}
+	}
+}
+
+// publish makes the includes and the directives used by this parse visible
+// on the Config
+func (p *parser) publish() {
+	p.cfg.Lock()
+	defer p.cfg.Unlock()
+
+	p.cfg.includes = p.includes
+	for _, c := range p.cfg.directives {
+		c.seen = nil
+	}
+	for c, pos := range p.seen {
+		c.Runnable = true
+		c.seen = pos
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	var level string
+	var cfg *Config
+	err := unmarshalString(t, "name a;\nlevel debug;\nserver {\n    cache on;\n}\ncache off;\n", &arityConf{}, func(c *Config) {
+		cfg = c
+		_, err := c.Directive("level", &level, Take1)
+		wantError(t, err, "")
+		_, err = c.HandleFunc("cache", func(ctx *DirectiveContext, args []string) error { return nil }, Flag, Contexts("main", "server"))
+		wantError(t, err, "")
+		_, err = c.HandleBlock("unused", func(ctx *DirectiveContext, args []string, b *Block) error { return nil })
+		wantError(t, err, "")
+	})
+	wantError(t, err, "")
+
+	infos := cfg.Directives()
+	want := []DirectiveInfo{
+		{Name: "cache", Type: reflect.TypeOf(HandlerFunc(nil)), Arity: Flag, Contexts: []string{"main", "server"}, Seen: true, Line: 4},
+		{Name: "level", Type: reflect.TypeOf(level), Arity: Take1, Contexts: []string{"main"}, Seen: true, Line: 2},
+		// a block handler requires a block
+		{Name: "unused", Type: reflect.TypeOf(BlockHandlerFunc(nil)), Arity: TakeBlock},
+	}
+	if len(infos) != len(want) {
+		t.Fatalf("directives = %+v", infos)
+	}
+	for i, w := range want {
+		got := infos[i]
+		if got.Name != w.Name || got.Type != w.Type || got.Arity != w.Arity || got.Seen != w.Seen || got.Line != w.Line ||
+			len(got.Contexts) != len(w.Contexts) || (got.Seen && got.File == "") {
+			t.Fatalf("directive %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestRemoveDirective(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\nextra 1;\n"})
+
+	err := unmarshalFile(t, dir, "a.conf", &arityConf{}, func(cfg *Config) {
+		_, err := cfg.HandleFunc("extra", func(ctx *DirectiveContext, args []string) error { return nil })
+		wantError(t, err, "")
+		wantError(t, cfg.RemoveDirective("extra"), "")
+		wantError(t, cfg.RemoveDirective("extra"), "directive [ extra ] not found in ")
+		if n := len(cfg.Directives()); n != 0 {
+			t.Fatalf("%d directives after RemoveDirective", n)
+		}
+
+		// it can be registered again
+		_, err = cfg.HandleFunc("extra", func(ctx *DirectiveContext, args []string) error { return nil })
+		wantError(t, err, "")
+		wantError(t, cfg.RemoveDirective("extra"), "")
+	})
+	wantError(t, err, "unknown directive extra  in a.conf:2")
+}
+
+func TestRegisterWhileParsing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "plugin;\nextra 1;\nplugin;\n"})
+
+	var cfg *Config
+	var extra []string
+	plugin := func(ctx *DirectiveContext, args []string) error {
+		// like a plugin loaded by a directive, registered again on each use
+		_, err := cfg.HandleFunc("extra", func(ctx *DirectiveContext, args []string) error {
+			extra = append(extra, args...)
+			return nil
+		})
+		return err
+	}
+
+	err := unmarshalFile(t, dir, "a.conf", &arityConf{}, func(c *Config) {
+		cfg = c
+		_, err := c.HandleFunc("plugin", plugin, NoArgs)
+		wantError(t, err, "")
+	})
+	wantError(t, err, "")
+	if len(extra) != 1 || extra[0] != "1" {
+		t.Fatalf("extra = %q", extra)
+	}
+
+	// the next parse registers it again
+	wantError(t, cfg.Reload(), "")
+	if len(extra) != 2 {
+		t.Fatalf("extra = %q after reload", extra)
+	}
+
+	// a directive registered out of a parse is not replaced
+	_, err = cfg.HandleFunc("plugin", plugin)
+	wantError(t, err, "directive [ Plugin ] duplication")
+}
+
+func TestDirectivesDuringParse(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.conf":      "name a;\nfor $i in 0..9 {\n    server { include common.conf; }\n}\n",
+		"common.conf": "name x;\nhandled $i;\n",
+	})
+	cfg := New(filepath.Join(dir, "a.conf"))
+	_, err := cfg.HandleFunc("handled", func(ctx *DirectiveContext, args []string) error { return nil }, Take1)
+	wantError(t, err, "")
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := cfg.Unmarshal(&arityConf{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	var inspect sync.WaitGroup
+	inspect.Add(1)
+	go func() {
+		defer inspect.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			for _, d := range cfg.Directives() {
+				if d.Name != "handled" || d.Arity != Take1 {
+					t.Errorf("unexpected directive %+v", d)
+					return
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	inspect.Wait()
+
+	infos := cfg.Directives()
+	if len(infos) != 1 || !infos[0].Seen || infos[0].Line != 2 {
+		t.Fatalf("directives = %+v", infos)
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Reloader is implemented by Config and Holder
+type Reloader interface {
+	Reload() error
+}
+
+// ReloadOnSignal reloads r each time one of sigs is received, SIGHUP if none
+// is given. The result of each reload is sent on the returned channel, which
+// is closed when ctx is done. Reloads run one at a time and signals received
+// during a reload are merged into the next one, the channel must be drained.
+func ReloadOnSignal(ctx context.Context, r Reloader, sigs ...os.Signal) <-chan error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	results := make(chan error, 1)
+	go func() {
+		defer close(results)
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+			}
+
+			err := r.Reload()
+			select {
+			case results <- err:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results
+}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\n"})
+	path := filepath.Join(dir, "a.conf")
+
+	h, err := NewHolder[holderConf](New(path))
+	wantError(t, err, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := ReloadOnSignal(ctx, h, syscall.SIGHUP)
+
+	reload := func(src, want string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-results:
+			wantError(t, err, want)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+	}
+
+	reload("name b;\n", "")
+	if h.Load().Name != "b" {
+		t.Fatalf("name = %q, want b", h.Load().Name)
+	}
+
+	reload("bad;\n", "unknown directive")
+	if h.Load().Name != "b" {
+		t.Fatalf("name = %q after a failed reload, want b", h.Load().Name)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Fatal("unexpected result after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("results not closed after cancel")
+	}
+}
@@ -1,12 +1,14 @@
 package config
 
 import (
-	"os"
+	"io"
 	"reflect"
 )
 
 type stash struct {
-	file           *os.File
+	file           io.Reader
+	filename       string
+	via            string
 	line           int64
 	searchVal      bool
 	searchKey      bool
@@ -15,74 +17,102 @@ type stash struct {
 	skip           bool
 	bkQueue        []bool
 	bkMulti        bool
+	bkCond         []int
+	condState      int
 	mapKey         reflect.Value
 	setVar         bool
 	searchVar      bool
 	searchVarBlock bool
 	cwd            string
 	vars           []map[string]string
+	currentVar     map[string]string
+	current        reflect.Value
+	queue          int
 }
 
-func (cfg *Config) popStash() error {
-	if cfg.inBlock > 0 {
-		return cfg.error("invalid config file, block not closed by \"}\"")
+func (p *parser) popStash() error {
+	if p.inBlock > 0 {
+		return p.error("invalid config file, block not closed by \"}\"")
 	}
 
-	if len(cfg.stash) > 0 {
-		current := cfg.stash[len(cfg.stash)-1]
-		cfg.stash = cfg.stash[:len(cfg.stash)-1]
-		cfg.file = current.file
-		cfg.line = current.line
-		cfg.searchVal = current.searchVal
-		cfg.searchKey = current.searchKey
-		cfg.inBlock = current.inBlock
-		cfg.canSkip = current.canSkip
-		cfg.skip = current.skip
-		cfg.bkMulti = current.bkMulti
-		cfg.mapKey = current.mapKey
-		cfg.setVar = current.setVar
-		cfg.searchVar = current.searchVar
-		cfg.searchVarBlock = current.searchVarBlock
-		cfg.cwd = current.cwd
+	if len(p.stash) > 0 {
+		current := p.stash[len(p.stash)-1]
+		p.stash = p.stash[:len(p.stash)-1]
+		p.file = current.file
+		p.filename = current.filename
+		p.via = current.via
+		p.line = current.line
+		p.searchVal = current.searchVal
+		p.searchKey = current.searchKey
+		p.inBlock = current.inBlock
+		p.canSkip = current.canSkip
+		p.skip = current.skip
+		p.bkMulti = current.bkMulti
+		p.condState = current.condState
+		p.mapKey = current.mapKey
+		p.setVar = current.setVar
+		p.searchVar = current.searchVar
+		p.searchVarBlock = current.searchVarBlock
+		p.cwd = current.cwd
+		p.currentVar = current.currentVar
 
-		cfg.bkQueue = make([]bool, len(current.bkQueue))
-		cfg.vars = make([]map[string]string, len(current.vars))
-		copy(cfg.bkQueue, current.bkQueue)
-		copy(cfg.vars, current.vars)
+		// directives of the included file belong to the block of the include
+		p.current = current.current
+		p.queue = p.queue[:current.queue]
+
+		p.bkQueue = make([]bool, len(current.bkQueue))
+		p.bkCond = make([]int, len(current.bkCond))
+		p.vars = make([]map[string]string, len(current.vars))
+		copy(p.bkQueue, current.bkQueue)
+		copy(p.bkCond, current.bkCond)
+		copy(p.vars, current.vars)
 	}
 
 	return nil
 }
 
-func (cfg *Config) pushStash() {
+func (p *parser) pushStash() {
+	p.fixedElement()
 	s := &stash{
-		file:           cfg.file,
-		line:           cfg.line,
-		searchVal:      cfg.searchVal,
-		searchKey:      cfg.searchKey,
-		inBlock:        cfg.inBlock,
-		canSkip:        cfg.canSkip,
-		skip:           cfg.skip,
-		bkMulti:        cfg.bkMulti,
-		mapKey:         cfg.mapKey,
-		setVar:         cfg.setVar,
-		searchVar:      cfg.searchVar,
-		searchVarBlock: cfg.searchVarBlock,
-		cwd:            cfg.cwd,
+		file:           p.file,
+		filename:       p.filename,
+		via:            p.via,
+		line:           p.line,
+		searchVal:      p.searchVal,
+		searchKey:      p.searchKey,
+		inBlock:        p.inBlock,
+		canSkip:        p.canSkip,
+		skip:           p.skip,
+		bkMulti:        p.bkMulti,
+		condState:      p.condState,
+		mapKey:         p.mapKey,
+		setVar:         p.setVar,
+		searchVar:      p.searchVar,
+		searchVarBlock: p.searchVarBlock,
+		cwd:            p.cwd,
+		currentVar:     p.currentVar,
+		current:        p.current,
+		queue:          len(p.queue),
 	}
 
-	s.bkQueue = make([]bool, len(cfg.bkQueue))
-	s.vars = make([]map[string]string, len(cfg.vars))
-	copy(s.bkQueue, cfg.bkQueue)
-	copy(s.vars, cfg.vars)
+	s.bkQueue = make([]bool, len(p.bkQueue))
+	s.bkCond = make([]int, len(p.bkCond))
+	s.vars = make([]map[string]string, len(p.vars))
+	copy(s.bkQueue, p.bkQueue)
+	copy(s.bkCond, p.bkCond)
+	copy(s.vars, p.vars)
 
-	cfg.inSearchKey()
-	cfg.inBlock = 0
-	cfg.inInclude = 0
-	cfg.currentVar = make(map[string]string)
-	cfg.searchVar = false
-	cfg.setVar = false
-	cfg.searchVarBlock = false
+	p.inSearchKey()
+	p.inBlock = 0
+	p.bkCond = nil
+	p.condState = condNone
+	p.inInclude = 0
+	// variables of the enclosing blocks are visible in the included file
+	p.vars = append(p.vars, p.currentVar)
+	p.currentVar = make(map[string]string)
+	p.searchVar = false
+	p.setVar = false
+	p.searchVarBlock = false
 
-	cfg.stash = append(cfg.stash, s)
+	p.stash = append(p.stash, s)
 }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHolderOnChange(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\nlog_file a.log;\nserver { listen x; port 80; }\nserver { listen y; port 81; }\n"})
+	path := filepath.Join(dir, "a.conf")
+
+	h, err := NewHolder[holderConf](New(path))
+	wantError(t, err, "")
+
+	calls := make(map[string][]Change)
+	var veto error
+	for _, pattern := range []string{"log_file", "server.*.port", "server.1", ""} {
+		pattern := pattern
+		h.OnChange(pattern, func(changes []Change) error {
+			calls[pattern] = append(calls[pattern], changes...)
+			if pattern == "server.1" {
+				return veto
+			}
+			return nil
+		})
+	}
+
+	reload := func(src string) error {
+		t.Helper()
+		for k := range calls {
+			delete(calls, k)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return h.Reload()
+	}
+
+	// only the paths with changes are called
+	wantError(t, reload("name a;\nlog_file b.log;\nserver { listen x; port 80; }\nserver { listen y; port 81; }\n"), "")
+	if len(calls["log_file"]) != 1 || calls["log_file"][0].New != "b.log" || len(calls["server.*.port"]) != 0 || len(calls["server.1"]) != 0 || len(calls[""]) != 1 {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	wantError(t, reload("name a;\nlog_file b.log;\nserver { listen x; port 8080; }\nserver { listen y; port 81; }\n"), "")
+	if c := calls["server.*.port"]; len(c) != 1 || c[0].Path != "server[0].port" || c[0].Old != "80" || c[0].New != "8080" {
+		t.Fatalf("calls = %v", calls)
+	}
+	if len(calls["log_file"]) != 0 || len(calls["server.1"]) != 0 {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	// a veto rolls back the callbacks already run and keeps the value
+	veto = errors.New("port in use")
+	before := h.Load()
+	err = reload("name a;\nlog_file c.log;\nserver { listen x; port 8080; }\nserver { listen y; port 82; }\n")
+	wantError(t, err, "port in use")
+	if h.Load() != before {
+		t.Fatal("the value changed after a veto")
+	}
+
+	rollback := []Change{
+		{Path: "log_file", Old: "b.log", New: "c.log", Kind: Modified},
+		{Path: "log_file", Old: "c.log", New: "b.log", Kind: Modified},
+	}
+	if c := calls["log_file"]; len(c) != 2 || c[0] != rollback[0] || c[1] != rollback[1] {
+		t.Fatalf("log_file calls = %v", c)
+	}
+	if c := calls["server.*.port"]; len(c) != 2 || c[1].Old != "82" || c[1].New != "81" {
+		t.Fatalf("server.*.port calls = %v", c)
+	}
+	// the callbacks after the veto are not called
+	if len(calls[""]) != 0 {
+		t.Fatalf("calls = %v", calls)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxUse is the default limit of total template uses
+const DefaultMaxUse = 10000
+
+type template struct {
+	name     string
+	body     []byte
+	filename string
+	line     int64
+}
+
+// startDefine is called with the "{" after define name, the body is kept as it is
+func (p *parser) startDefine(s string, reader *bufio.Reader) error {
+	p.inDefine = false
+	p.inSearchKey()
+
+	name := strings.TrimSpace(s)
+	if name == "" || len(strings.Fields(name)) != 1 {
+		return p.error("invalid template name \"%s\"", name)
+	}
+
+	if t, ok := p.templates[name]; ok {
+		return p.error("template \"%s\" already defined at %s:%d", name, t.filename, t.line)
+	}
+
+	t := &template{name: name, filename: p.filename, line: p.line}
+	body, err := p.captureBlock(reader)
+	if err != nil {
+		return err
+	}
+	t.body = body
+	p.templates[name] = t
+
+	return nil
+}
+
+// use splices the directives of a template, "use name key=value ...;"
+func (p *parser) use(s string) error {
+	args, err := p.splitQuoted(p.expand(s))
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 {
+		return p.error("\"use\" requires a template name")
+	}
+
+	t, ok := p.templates[args[0]]
+	if !ok {
+		return p.error("unknown template \"%s\"", args[0])
+	}
+
+	for _, name := range p.using {
+		if name == t.name {
+			return p.error("template \"%s\" used recursively", t.name)
+		}
+	}
+
+	// nested templates using another one twice grow exponentially
+	p.uses++
+	if p.uses > p.maxUse {
+		return p.error("too many template uses, exceeds %d limit", p.maxUse)
+	}
+
+	vars := make(map[string]string)
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return p.error("invalid parameter \"%s\" of template \"%s\", key=value required", arg, t.name)
+		}
+		vars[strings.TrimPrefix(kv[0], "$")] = kv[1]
+	}
+
+	via := fmt.Sprintf("template \"%s\" used in %s:%d", t.name, p.filename, p.line)
+	if p.via != "" {
+		via += ", " + p.via
+	}
+
+	p.using = append(p.using, t.name)
+	if err := p.splice(t.body, t.filename, t.line, vars, via); err != nil {
+		return err
+	}
+	p.using = p.using[:len(p.using)-1]
+
+	return nil
+}
+
+// splice parses body in the current block, vars are scoped to the body
+func (p *parser) splice(body []byte, filename string, line int64, vars map[string]string, via string) error {
+	p.pushStash()
+	p.currentVar = vars
+	p.filename = filename
+	p.line = line
+	p.via = via
+
+	return p.parseReader(bufio.NewReader(bytes.NewReader(body)))
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type templateServer struct {
+	Listen  string
+	Cert    string
+	Timeout string
+}
+
+type templateConf struct {
+	Name   string
+	Server []templateServer
+}
+
+func TestTemplate(t *testing.T) {
+	src := `
+set timeout 10s;
+define tls_defaults {
+    cert $cert;
+    timeout $timeout;
+}
+server {
+    listen 443;
+    use tls_defaults cert=/etc/ssl/a.pem;
+}
+server {
+    set timeout 30s;
+    use tls_defaults cert=/etc/ssl/b.pem;
+    listen 8443;
+}
+name $cert;
+`
+	got := &templateConf{}
+	err := unmarshalString(t, src, got)
+	wantError(t, err, "")
+
+	want := []templateServer{
+		{Listen: "443", Cert: "/etc/ssl/a.pem", Timeout: "10s"},
+		{Listen: "8443", Cert: "/etc/ssl/b.pem", Timeout: "30s"},
+	}
+	if len(got.Server) != len(want) {
+		t.Fatalf("server = %+v", got.Server)
+	}
+	for i := range want {
+		if got.Server[i] != want[i] {
+			t.Fatalf("server[%d] = %+v, want %+v", i, got.Server[i], want[i])
+		}
+	}
+
+	// parameters are scoped to the template
+	if got.Name != "$cert" {
+		t.Fatalf("name = %q, want $cert", got.Name)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "template line and use line",
+			src:  "define t {\n    listen 80;\n    unknown 1;\n}\nserver {\n    use t;\n}\n",
+			err:  "unknown directive unknown  in test.conf:3 (template \"t\" used in test.conf:6)",
+		},
+		{
+			name: "nested use",
+			src:  "define a {\n    bad;\n}\ndefine b {\n    use a;\n}\nserver {\n    use b;\n}\n",
+			err:  "in test.conf:2 (template \"a\" used in test.conf:5, template \"b\" used in test.conf:8)",
+		},
+		{name: "unknown", src: `server { use t; }`, err: "unknown template \"t\""},
+		{name: "recursive", src: `define t { use t; } server { use t; }`, err: "template \"t\" used recursively"},
+		{name: "defined twice", src: "define t { }\ndefine t { }\n", err: "template \"t\" already defined at test.conf:1"},
+		{name: "invalid parameter", src: `define t { } server { use t cert; }`, err: "invalid parameter \"cert\" of template \"t\", key=value required"},
+		{name: "invalid name", src: `define { }`, err: "invalid template name \"\""},
+		{name: "without block", src: `define t;`, err: "\"define\" requires a block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unmarshalString(t, tt.src, &templateConf{})
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestTemplateLimit(t *testing.T) {
+	// each level uses the one below twice, 2^40 uses without a limit
+	var buf strings.Builder
+	buf.WriteString("define t0 { listen 80; }\n")
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&buf, "define t%d { use t%d; use t%d; }\n", i, i-1, i-1)
+	}
+	exponential := buf.String() + "server { use t40; }\n"
+
+	tests := []struct {
+		name string
+		src  string
+		opts []Option
+		err  string
+	}{
+		{name: "exponential", src: exponential, err: "too many template uses, exceeds 10000 limit"},
+		{name: "within limit", src: "define t { listen 80; }\nserver { use t; }\nserver { use t; }\n", opts: []Option{WithMaxUse(2)}},
+		{name: "over limit", src: "define t { listen 80; }\nserver { use t; }\nserver { use t; use t; }\n", opts: []Option{WithMaxUse(2)}, err: "exceeds 2 limit in <bytes>:3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBytes[templateConf]([]byte(tt.src), tt.opts...)
+			wantError(t, err, tt.err)
+		})
+	}
+}
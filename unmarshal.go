@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+)
+
+// ConfigUnmarshaler is implemented by types decoding the whole directive, the
+// arguments and the block if any, "name args { ... }". block is nil for a
+// directive without a block.
+type ConfigUnmarshaler interface {
+	UnmarshalConfig(args []string, block *Block) error
+}
+
+var (
+	configUnmarshalerType = reflect.TypeOf((*ConfigUnmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isConfigUnmarshaler reports whether a value of t, or each element of a
+// slice t, is decoded by UnmarshalConfig
+func isConfigUnmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.PtrTo(t).Implements(configUnmarshalerType)
+}
+
+// textUnmarshaler returns the current value as an encoding.TextUnmarshaler,
+// like net.IP and slog.Level
+func (p *parser) textUnmarshaler() (encoding.TextUnmarshaler, bool) {
+	if !p.current.CanAddr() || !reflect.PtrTo(p.current.Type()).Implements(textUnmarshalerType) {
+		return nil, false
+	}
+	return p.current.Addr().Interface().(encoding.TextUnmarshaler), true
+}
+
+// unmarshalDirective starts the directive name of the current value if it's a
+// ConfigUnmarshaler, it's kept as a Directive until it's complete. A slice
+// gets a new element for each directive.
+func (p *parser) unmarshalDirective(name string) bool {
+	v := p.current
+	if !isConfigUnmarshaler(v.Type()) {
+		return false
+	}
+
+	if v.Kind() == reflect.Slice {
+		n := v.Len()
+		v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		v = v.Index(n)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	d := p.newDirective(name)
+	d.unmarshaler = v.Addr().Interface().(ConfigUnmarshaler)
+	p.current = reflect.ValueOf(d).Elem()
+	return true
+}
+
+// isUnmarshaler reports whether s is a field or a directive decoded by
+// UnmarshalConfig, it can be written without arguments
+func (p *parser) isUnmarshaler(s string) bool {
+	name := p.fixedField(strings.TrimSpace(s))
+
+	v := p.current
+	if !v.IsValid() {
+		v = p.entry
+	}
+	if v.IsValid() {
+		t := v.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			if f, ok := t.FieldByName(name); ok {
+				return isConfigUnmarshaler(f.Type)
+			}
+		}
+	}
+
+	rev, ok := p.directive(name)
+	return ok && rev.config.IsValid() && isConfigUnmarshaler(rev.config.Type())
+}
+
+func (p *parser) runUnmarshaler(d *Directive) error {
+	if err := d.unmarshaler.UnmarshalConfig(d.Args, d.Block); err != nil {
+		return p.wrapError(d.File, d.Line, err)
+	}
+	return nil
+}
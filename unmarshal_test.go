@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type routes struct {
+	Args  []string
+	Paths []string
+	Block bool
+}
+
+func (r *routes) UnmarshalConfig(args []string, block *Block) error {
+	if len(args) > 0 && args[0] == "fail" {
+		return errors.New("routes failed")
+	}
+	r.Args = args
+	r.Block = block != nil
+	for _, d := range block.Find("path") {
+		r.Paths = append(r.Paths, d.Args...)
+	}
+	return nil
+}
+
+// logLevel is a value field decoded by its pointer, like slog.Level
+type logLevel int
+
+const (
+	levelDebug logLevel = iota - 1
+	levelInfo
+	levelWarn
+)
+
+func (l *logLevel) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "debug":
+		*l = levelDebug
+	case "info":
+		*l = levelInfo
+	case "warn":
+		*l = levelWarn
+	default:
+		return fmt.Errorf("unknown name %q", b)
+	}
+	return nil
+}
+
+type textConf struct {
+	Ip      net.IP
+	Ips     []net.IP
+	Mask    *net.IP
+	Big     *big.Int
+	Level   logLevel `hook:"not_debug"`
+	Started time.Time
+	Routes  routes
+	Route   []*routes
+	Hosts   map[string]net.IP
+}
+
+func (c *textConf) NotDebug(ctx *HookContext) error {
+	if ctx.Value.Int() < 0 {
+		return errors.New("debug level is not allowed")
+	}
+	return nil
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	src := `
+set host 10.0.0.9;
+ip 10.0.0.1;
+ips 10.0.0.2 "10.0.0.3";
+ips ::1;
+mask $host;
+big 123456789012345678901234567890;
+level warn;
+started 2024-01-02T03:04:05Z;
+hosts a 1.2.3.4;
+`
+	got, err := LoadBytes[textConf]([]byte(src))
+	wantError(t, err, "")
+
+	if !got.Ip.Equal(net.ParseIP("10.0.0.1")) || len(got.Ips) != 3 || !got.Ips[2].Equal(net.IPv6loopback) || !got.Mask.Equal(net.ParseIP("10.0.0.9")) {
+		t.Fatalf("ip %v, ips %v, mask %v", got.Ip, got.Ips, got.Mask)
+	}
+	if got.Big.String() != "123456789012345678901234567890" || got.Level != levelWarn {
+		t.Fatalf("big %v, level %v", got.Big, got.Level)
+	}
+	if !got.Started.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) || !got.Hosts["a"].Equal(net.ParseIP("1.2.3.4")) {
+		t.Fatalf("started %v, hosts %v", got.Started, got.Hosts)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "invalid ip", src: `ip 999.1.1.1;`, err: "invalid IP address: 999.1.1.1 in <bytes>:1"},
+		{name: "invalid level", src: `level loud;`, err: "unknown name"},
+		{name: "invalid big", src: "\nbig 12x;", err: "in <bytes>:2"},
+		{name: "hook", src: `level debug;`, err: "debug level is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBytes[textConf]([]byte(tt.src))
+			wantError(t, err, tt.err)
+		})
+	}
+}
+
+func TestConfigUnmarshaler(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		want  routes
+		route int
+		err   string
+	}{
+		{name: "block", src: `set p /c; routes api { path /a /b; path $p; nested { path /d; } }`, want: routes{Args: []string{"api"}, Paths: []string{"/a", "/b", "/c"}, Block: true}},
+		{name: "arguments", src: `routes a "b c";`, want: routes{Args: []string{"a", "b c"}}},
+		{name: "bare", src: `routes;`, want: routes{Args: []string{}}},
+		{name: "empty block", src: `routes { }`, want: routes{Args: []string{}, Block: true}},
+		{name: "slice", src: `route a; route b { path /b; } route;`, route: 3},
+		{name: "error", src: "routes ok;\nroutes fail { }", err: "routes failed in <bytes>:2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadBytes[textConf]([]byte(tt.src))
+			wantError(t, err, tt.err)
+			if err != nil {
+				return
+			}
+
+			r := got.Routes
+			if len(r.Args) != len(tt.want.Args) || len(r.Paths) != len(tt.want.Paths) || r.Block != tt.want.Block {
+				t.Fatalf("got %+v, want %+v", r, tt.want)
+			}
+			for i := range tt.want.Args {
+				if r.Args[i] != tt.want.Args[i] {
+					t.Fatalf("got %+v, want %+v", r, tt.want)
+				}
+			}
+			for i := range tt.want.Paths {
+				if r.Paths[i] != tt.want.Paths[i] {
+					t.Fatalf("got %+v, want %+v", r, tt.want)
+				}
+			}
+			if len(got.Route) != tt.route {
+				t.Fatalf("route = %+v", got.Route)
+			}
+		})
+	}
+}
+
+func TestConfigUnmarshalerDirective(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "routes x { path /x; }\nname a;\n"})
+
+	// Parse requires an entry which is not zero
+	entry := struct{ Name string }{Name: "default"}
+	var r routes
+	cfg := New(filepath.Join(dir, "a.conf"))
+	wantError(t, cfg.Entry(&entry), "")
+	if _, err := cfg.Directive("routes", &r, Take1|TakeBlock); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, cfg.Parse(), "")
+	if len(r.Args) != 1 || r.Args[0] != "x" || len(r.Paths) != 1 || entry.Name != "a" {
+		t.Fatalf("routes %+v, entry %+v", r, entry)
+	}
+}
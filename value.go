@@ -9,144 +9,170 @@ import (
 	"time"
 )
 
-func (cfg *Config) set(s string) error {
+func (p *parser) set(s string) error {
 	s = strings.TrimSpace(s)
-	if cfg.current.Kind() == reflect.Ptr {
-		if cfg.current.IsNil() {
-			cfg.current.Set(reflect.New(cfg.current.Type().Elem()))
+	if err := p.checkCommand(s, false); err != nil {
+		return err
+	}
+	if p.inTree(directiveType) {
+		return p.setDirective(s)
+	}
+
+	if p.current.Kind() == reflect.Ptr {
+		if p.current.IsNil() {
+			p.current.Set(reflect.New(p.current.Type().Elem()))
 		}
-		cfg.current = cfg.current.Elem()
+		p.current = p.current.Elem()
 	}
 
 	// 这里判定一下，是不是要格式化数值
-	if format := cfg.typ.Tag.Get("format"); format != "" {
-		if err := cfg.setByFormat(format, s); err != nil {
+	if format := p.typ.Tag.Get("format"); format != "" {
+		if err := p.setByFormat(format, s); err != nil {
 			return err
 		}
 	} else {
-		if err := cfg.setByRaw(s); err != nil {
+		if err := p.setByRaw(s); err != nil {
 			return err
 		}
 	}
 
-	// hook check
-	if hook := cfg.typ.Tag.Get("hook"); hook != "" {
-		return cfg.runHook(hook, s)
+	// hook check, hooks of a slice run for each element, of a map for each
+	// key and value, runHook keeps only unique for keys
+	if hook := p.typ.Tag.Get("hook"); hook != "" {
+		if _, ok := p.textUnmarshaler(); ok || (p.current.Kind() != reflect.Slice && p.current.Kind() != reflect.Map) {
+			return p.runHook(hook, s)
+		}
 	}
 
 	return nil
 }
 
-func (cfg *Config) setByRaw(s string) error {
-	switch cfg.current.Kind() {
+func (p *parser) setByRaw(s string) error {
+	if u, ok := p.textUnmarshaler(); ok {
+		if err := u.UnmarshalText([]byte(p.clearQuoted(s))); err != nil {
+			return p.error(err.Error())
+		}
+		return nil
+	}
+
+	switch p.current.Kind() {
 	case reflect.String:
-		cfg.current.SetString(cfg.clearQuoted(s))
+		p.current.SetString(p.clearQuoted(s))
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if cfg.current.Type().String() == "time.Duration" {
+		if p.current.Type().String() == "time.Duration" {
 			if time, err := time.ParseDuration(s); err != nil {
-				return cfg.error(err.Error())
+				return p.error(err.Error())
 			} else {
-				cfg.current.Set(reflect.ValueOf(time))
+				p.current.Set(reflect.ValueOf(time))
 			}
 		} else {
-			itmp, err := strconv.ParseInt(s, 10, cfg.current.Type().Bits())
+			itmp, err := strconv.ParseInt(s, 10, p.current.Type().Bits())
 			if err != nil {
-				return cfg.error(err.Error())
+				return p.error(err.Error())
 			}
-			if !cfg.current.OverflowInt(itmp) {
-				cfg.current.SetInt(itmp)
+			if !p.current.OverflowInt(itmp) {
+				p.current.SetInt(itmp)
 			} else {
-				return cfg.error("value overflow")
+				return p.error("value overflow")
 			}
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		itmp, err := strconv.ParseUint(s, 10, cfg.current.Type().Bits())
+		itmp, err := strconv.ParseUint(s, 10, p.current.Type().Bits())
 		if err != nil {
-			return cfg.error(err.Error())
+			return p.error(err.Error())
 		}
-		if !cfg.current.OverflowUint(itmp) {
-			cfg.current.SetUint(itmp)
+		if !p.current.OverflowUint(itmp) {
+			p.current.SetUint(itmp)
 		} else {
-			return cfg.error("value overflow")
+			return p.error("value overflow")
 		}
 	case reflect.Float32, reflect.Float64:
-		ftmp, err := strconv.ParseFloat(s, cfg.current.Type().Bits())
+		ftmp, err := strconv.ParseFloat(s, p.current.Type().Bits())
 		if err != nil {
-			return cfg.error(err.Error())
+			return p.error(err.Error())
 		}
-		if !cfg.current.OverflowFloat(ftmp) {
-			cfg.current.SetFloat(ftmp)
+		if !p.current.OverflowFloat(ftmp) {
+			p.current.SetFloat(ftmp)
 		} else {
-			return cfg.error("value overflow")
+			return p.error("value overflow")
 		}
 	case reflect.Bool:
 		if s == "yes" || s == "on" {
-			cfg.current.SetBool(true)
+			p.current.SetBool(true)
 		} else if s == "no" || s == "off" {
-			cfg.current.SetBool(false)
+			p.current.SetBool(false)
 		} else {
 			btmp, err := strconv.ParseBool(s)
 			if err != nil {
-				return cfg.error(err.Error())
+				return p.error(err.Error())
 			}
-			cfg.current.SetBool(btmp)
+			p.current.SetBool(btmp)
 		}
 	case reflect.Slice:
-		sf, err := cfg.splitQuoted(s)
+		sf, err := p.splitQuoted(s)
 		if err != nil {
 			return err
 		}
 		for _, sv := range sf {
-			n := cfg.current.Len()
-			ref := reflect.Zero(cfg.current.Type().Elem())
-			cfg.init(ref)
-			cfg.current.Set(reflect.Append(cfg.current, ref))
-			cfg.pushElement(cfg.current.Index(n))
-			cfg.set(sv)
-			cfg.popElement()
+			n := p.current.Len()
+			ref := reflect.Zero(p.current.Type().Elem())
+			p.init(ref)
+			p.current.Set(reflect.Append(p.current, ref))
+			p.pushElement(p.current.Index(n))
+			if err := p.set(sv); err != nil {
+				return err
+			}
+			p.popElement()
 		}
 	case reflect.Map:
-		if cfg.current.IsNil() {
-			cfg.current.Set(reflect.MakeMap(cfg.current.Type()))
+		if p.current.IsNil() {
+			p.current.Set(reflect.MakeMap(p.current.Type()))
 		}
 
-		sf, err := cfg.splitQuoted(s)
+		sf, err := p.splitQuoted(s)
 		if err != nil {
 			return err
 		}
 		if len(sf) != 2 {
-			return cfg.error("invalid map config: %s", s)
+			return p.error("invalid map config: %s", s)
 		}
 		var v reflect.Value
-		v = reflect.New(cfg.current.Type().Key())
-		cfg.pushElement(v)
-		cfg.set(sf[0])
-		key := cfg.current
-		cfg.popElement()
-		v = reflect.New(cfg.current.Type().Elem())
-		cfg.pushElement(v)
-		cfg.set(sf[1])
-		val := cfg.current
-		cfg.popElement()
-
-		cfg.current.SetMapIndex(key, val)
+		v = reflect.New(p.current.Type().Key())
+		p.pushElement(v)
+		p.inMapKey = true
+		err = p.set(sf[0])
+		p.inMapKey = false
+		if err != nil {
+			return err
+		}
+		key := p.current
+		p.popElement()
+		v = reflect.New(p.current.Type().Elem())
+		p.pushElement(v)
+		if err := p.set(sf[1]); err != nil {
+			return err
+		}
+		val := p.current
+		p.popElement()
+
+		p.current.SetMapIndex(key, val)
 	default:
-		if cfg.current.Kind() == reflect.Struct {
-			return cfg.error("invalid block, start a block with '{'")
+		if p.current.Kind() == reflect.Struct {
+			return p.error("invalid block, start a block with '{'")
 		} else {
-			return cfg.error(fmt.Sprintf("invalid type:%s", cfg.current.Kind()))
+			return p.error(fmt.Sprintf("invalid type:%s", p.current.Kind()))
 		}
 	}
 
 	return nil
 }
 
-func (cfg *Config) setByFormat(format, s string) error {
+func (p *parser) setByFormat(format, s string) error {
 	var fn reflect.Value
 	found := false
-	method := cfg.fixedField(format)
+	method := p.fixedField(format)
 	// 在配置本身上面找
-	if element, ok := cfg.getStruct(); ok {
+	if element, ok := p.getStruct(); ok {
 		if element.Kind() != reflect.Ptr && element.CanAddr() {
 			element = element.Addr()
 		}
@@ -156,84 +182,161 @@ func (cfg *Config) setByFormat(format, s string) error {
 	}
 
 	if !found {
-		fn = reflect.ValueOf(cfg.format).MethodByName(method)
+		fn, found = p.registeredFormat(format)
+	}
+
+	if !found {
+		fn = reflect.ValueOf(p.format).MethodByName(method)
 
 		if !fn.IsValid() || fn.Kind() != reflect.Func {
-			return cfg.error("tag: fomrat:\"%s\" in %s, func not exists", format, cfg.typ.Name)
+			return p.error("tag: fomrat:\"%s\" in %s, func not exists", format, p.typ.Name)
 		}
 	}
 
 	if fn.Type().NumOut() != 2 {
-		return cfg.error("tag: fomrat:\"%s\" in %s, func return invalid result, 2 result required", format, cfg.typ.Name)
+		return p.error("tag: fomrat:\"%s\" in %s, func return invalid result, 2 result required", format, p.typ.Name)
 	}
 
-	if fn.Type().Out(0) != cfg.current.Type() {
-		return cfg.error("tag: fomrat:\"%s\" in %s, invalid val kind, result is %s, but %s required", format, cfg.typ.Name, fn.Type().Out(0).String(), cfg.current.Type().String())
+	if fn.Type().Out(0) != p.current.Type() {
+		return p.error("tag: fomrat:\"%s\" in %s, invalid val kind, result is %s, but %s required", format, p.typ.Name, fn.Type().Out(0).String(), p.current.Type().String())
 	}
 
 	if !fn.Type().Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-		return cfg.error("tag: fomrat:\"%s\" in %s, func return invalid result, error type required", format, cfg.typ.Name)
+		return p.error("tag: fomrat:\"%s\" in %s, func return invalid result, error type required", format, p.typ.Name)
 	}
 
 	result := fn.Call([]reflect.Value{reflect.ValueOf(s)})
 
 	if !result[1].IsNil() {
-		return cfg.error("tag: fomrat:\"%s\" in %s, invalid val with %s, and return %s", format, cfg.typ.Name, s, result[1].Interface().(error).Error())
+		return p.error("tag: fomrat:\"%s\" in %s, invalid val with %s, and return %s", format, p.typ.Name, s, result[1].Interface().(error).Error())
 	}
 
-	cfg.current.Set(result[0])
+	p.current.Set(result[0])
 	return nil
 }
 
-func (cfg *Config) replace(s *bytes.Buffer, vs *bytes.Buffer) bool {
-	if vs.Len() == 0 {
-		return false
+// expand replaces $name and ${name} in s with the value of the variable,
+// unknown variables are kept as they are
+func (p *parser) expand(s string) string {
+	if strings.IndexByte(s, '$') < 0 {
+		return s
 	}
 
-	for k, v := range cfg.currentVar {
-		if strings.Compare(k, vs.String()) == 0 {
-			// found
-			cfg.searchVar = false
-			s.WriteString(v)
-			vs.Reset()
-			return true
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
 		}
-	}
 
-	for i := len(cfg.vars) - 1; i >= 0; i-- {
-		for k, v := range cfg.vars[i] {
-			if strings.Compare(k, vs.String()) == 0 {
-				s.WriteString(v)
-				cfg.searchVar = false
-				vs.Reset()
-				return true
+		var name string
+		end := i + 1
+		if s[end] == '{' {
+			n := strings.IndexByte(s[end:], '}')
+			if n < 0 {
+				buf.WriteString(s[i:])
+				break
+			}
+			name = s[end+1 : end+n]
+			end += n + 1
+		} else {
+			for end < len(s) && p.isVarByte(s[end]) {
+				end++
 			}
+			name = s[i+1 : end]
+		}
+
+		if v, ok := p.lookupVar(name); ok {
+			buf.WriteString(v)
+		} else {
+			buf.WriteString(s[i:end])
+		}
+		i = end - 1
+	}
+
+	return buf.String()
+}
+
+func (p *parser) lookupVar(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+
+	if v, ok := p.currentVar[name]; ok {
+		return v, true
+	}
+
+	for i := len(p.vars) - 1; i >= 0; i-- {
+		if v, ok := p.vars[i][name]; ok {
+			return v, true
 		}
 	}
 
-	return false
+	v, ok := p.variables[name]
+	return v, ok
 }
 
-func (cfg *Config) runHook(hook string, s string) error {
-	hook = cfg.fixedField(hook)
-	fn, found := cfg.getMethod(hook)
+// runHook runs the hooks of a tag in order, like "unique,range(1,65535)"
+func (p *parser) runHook(tag string, s string) error {
+	calls, err := p.splitHooks(tag)
+	if err != nil {
+		return err
+	}
+
+	for _, call := range calls {
+		// the hooks of a map are for its values, only unique checks the keys
+		if p.inMapKey && p.fixedField(call.name) != "Unique" {
+			continue
+		}
+		if err := p.callHook(call, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// callHook calls a hook method of the config, or a built-in one, it takes a
+// *HookContext or the string
+func (p *parser) callHook(call hookCall, s string) error {
+	hook := p.fixedField(call.name)
+	fn, found := p.getMethod(hook)
+
+	if !found {
+		fn, found = p.registeredHook(call.name)
+	}
 
 	if !found {
-		fn = reflect.ValueOf(cfg.hook).MethodByName(hook)
+		fn = reflect.ValueOf(p.hook).MethodByName(hook)
 		if !fn.IsValid() || fn.Kind() != reflect.Func {
-			return cfg.error("tag: hook:\"%s\" in %s, func not exists", hook, cfg.typ.Name)
+			return p.error("tag: hook:\"%s\" in %s, func not exists", call.name, p.typ.Name)
 		}
 	}
 
 	if fn.Type().NumOut() != 1 {
-		return cfg.error("tag: hook:\"%s\" in %s, func return invalid result", hook, cfg.typ.Name)
+		return p.error("tag: hook:\"%s\" in %s, func return invalid result", call.name, p.typ.Name)
 	}
 
 	if !fn.Type().Out(0).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-		return cfg.error("tag: hook:\"%s\" in %s, func return invalid result, error required", hook, cfg.typ.Name)
+		return p.error("tag: hook:\"%s\" in %s, func return invalid result, error required", call.name, p.typ.Name)
 	}
 
-	result := fn.Call([]reflect.Value{reflect.ValueOf(s)})
+	var arg reflect.Value
+	switch {
+	case fn.Type().NumIn() != 1:
+		return p.error("tag: hook:\"%s\" in %s, func requires one param", call.name, p.typ.Name)
+	case fn.Type().In(0) == reflect.TypeOf(&HookContext{}):
+		arg = reflect.ValueOf(p.hookContext(s, call.args))
+	case fn.Type().In(0).Kind() == reflect.String:
+		if len(call.args) > 0 {
+			return p.error("tag: hook:\"%s\" in %s, func takes no arguments, *HookContext required", call.name, p.typ.Name)
+		}
+		arg = reflect.ValueOf(s)
+	default:
+		return p.error("tag: hook:\"%s\" in %s, invalid param, string or *HookContext required", call.name, p.typ.Name)
+	}
+
+	result := fn.Call([]reflect.Value{arg})
 
 	if !result[0].IsNil() {
 		return result[0].Interface().(error)
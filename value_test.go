@@ -0,0 +1,91 @@
+package config
+
+import "testing"
+
+type interpServer struct {
+	Listen string
+}
+
+type interpConf struct {
+	LogFile  string
+	Name     string
+	Upstream map[string]interpServer
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want interpConf
+		err  string
+	}{
+		{
+			name: "value",
+			src:  `set region us; log_file /var/log/$region.log;`,
+			want: interpConf{LogFile: "/var/log/us.log"},
+		},
+		{
+			name: "braces join text",
+			src:  `set region us; log_file ${region}_east;`,
+			want: interpConf{LogFile: "us_east"},
+		},
+		{
+			name: "block label",
+			src:  `set r eu; upstream ${r}_api { listen $r:80; }`,
+			want: interpConf{Upstream: map[string]interpServer{"eu_api": {Listen: "eu:80"}}},
+		},
+		{
+			name: "set name is literal",
+			src:  `set $region us; log_file $region;`,
+			want: interpConf{LogFile: "us"},
+		},
+		{
+			name: "unknown variable kept",
+			src:  `log_file /var/$x/${y}.log;`,
+			want: interpConf{LogFile: "/var/$x/${y}.log"},
+		},
+		{
+			name: "directive name is literal",
+			src:  `set d log_file; $d a;`,
+			err:  "unknown directive $d",
+		},
+		{
+			name: "unterminated braces",
+			src:  `set region us; log_file ${region;`,
+			err:  "variable is not terminated by \"}\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &interpConf{}
+			err := unmarshalString(t, tt.src, got)
+			wantError(t, err, tt.err)
+			if err != nil {
+				return
+			}
+			if got.LogFile != tt.want.LogFile || got.Name != tt.want.Name || len(got.Upstream) != len(tt.want.Upstream) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for k, v := range tt.want.Upstream {
+				if got.Upstream[k] != v {
+					t.Fatalf("upstream[%s] = %+v, want %+v", k, got.Upstream[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandIncludePath(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.conf":      "set region us;\ninclude conf.d/${region}.conf;\nset region eu;\ninclude conf.d/$region.conf;\n",
+		"conf.d/us.conf": "name us;\n",
+		"conf.d/eu.conf": "log_file eu;\n",
+	})
+
+	got := &interpConf{}
+	wantError(t, unmarshalFile(t, dir, "main.conf", got), "")
+	if got.Name != "us" || got.LogFile != "eu" {
+		t.Fatalf("got %+v", got)
+	}
+}
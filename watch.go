@@ -0,0 +1,256 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is the default interval of polling files
+const DefaultWatchInterval = time.Second
+
+// DefaultWatchDebounce is the default time to wait for more changes before a reload
+const DefaultWatchDebounce = 100 * time.Millisecond
+
+// notifier wakes the watcher up when something changes in the directories
+type notifier interface {
+	Add(dir string) error
+	Events() <-chan struct{}
+	Close() error
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher reloads the config when the root file, an included file, or the
+// files matched by an include glob change
+type Watcher struct {
+	// OnChange is called with the old and the new value after a reload
+	OnChange func(old, new interface{})
+	// OnError is called when a reload fails, the old value is kept
+	OnError func(err error)
+	// Interval is the interval of polling, used when inotify is not available
+	Interval time.Duration
+	// Debounce is the time to wait for more changes before a reload
+	Debounce time.Duration
+	// Polling disables inotify
+	Polling bool
+
+	mu      sync.Mutex
+	cfg     *Config
+	typ     reflect.Type
+	value   interface{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Config.Watch returns a watcher of the files of the config, v is the value
+// already unmarshaled, each reload unmarshals into a fresh value of its type
+func (cfg *Config) Watch(v interface{}) *Watcher {
+	return &Watcher{
+		Interval: DefaultWatchInterval,
+		Debounce: DefaultWatchDebounce,
+		cfg:      cfg,
+		typ:      reflect.TypeOf(v).Elem(),
+		value:    v,
+	}
+}
+
+// Watcher.Value returns the value of the last successful reload
+func (w *Watcher) Value() interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.value
+}
+
+// Watcher.Start starts watching in a goroutine
+func (w *Watcher) Start() error {
+	if w.done != nil {
+		return w.cfg.error("watcher already started")
+	}
+
+	var n notifier
+	if !w.Polling {
+		var err error
+		if n, err = newNotifier(); err != nil {
+			n = nil
+		}
+	}
+
+	last, dirs := w.snapshot()
+	if n != nil {
+		for _, dir := range dirs {
+			n.Add(dir)
+		}
+	}
+
+	w.done = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go w.run(n, last)
+
+	return nil
+}
+
+// Watcher.Close stops watching
+func (w *Watcher) Close() error {
+	if w.done == nil {
+		return nil
+	}
+
+	close(w.done)
+	<-w.stopped
+	w.done = nil
+	return nil
+}
+
+func (w *Watcher) run(n notifier, last map[string]fileState) {
+	defer close(w.stopped)
+
+	var events <-chan struct{}
+	var tick <-chan time.Time
+	if n != nil {
+		defer n.Close()
+		events = n.Events()
+	} else {
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	polled := last
+	timer := time.NewTimer(w.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-events:
+			timer.Reset(w.Debounce)
+		case <-tick:
+			// wait until files stop changing
+			if snap, _ := w.snapshot(); w.changed(polled, snap) {
+				polled = snap
+				timer.Reset(w.Debounce)
+			}
+		case <-timer.C:
+			snap, _ := w.snapshot()
+			if !w.changed(last, snap) {
+				continue
+			}
+
+			w.reload()
+
+			// files found by the reload are compared from now on, others
+			// are kept as they were before the reload to catch changes made
+			// while parsing
+			after, dirs := w.snapshot()
+			for path, st := range after {
+				if _, ok := snap[path]; !ok {
+					snap[path] = st
+				}
+			}
+			for path := range snap {
+				if _, ok := after[path]; !ok {
+					delete(snap, path)
+				}
+			}
+			last = snap
+			polled = snap
+
+			if n != nil {
+				for _, dir := range dirs {
+					n.Add(dir)
+				}
+			}
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	// the value given to OnChange is not the one of Config.Reload
+	v := reflect.New(w.typ).Interface()
+	if err := w.cfg.decode(v); err != nil {
+		if w.OnError != nil {
+			w.OnError(err)
+		}
+		return
+	}
+
+	w.mu.Lock()
+	old := w.value
+	w.value = v
+	w.mu.Unlock()
+
+	if w.OnChange != nil {
+		w.OnChange(old, v)
+	}
+}
+
+// snapshot returns the state of the watched files and their directories
+func (w *Watcher) snapshot() (map[string]fileState, []string) {
+	paths := []string{w.cfg.filename}
+	for _, inc := range w.cfg.Includes() {
+		paths = append(paths, inc.Files...)
+		for _, glob := range inc.globs {
+			files, _ := filepath.Glob(glob)
+			paths = append(paths, files...)
+		}
+	}
+
+	states := make(map[string]fileState)
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, path := range paths {
+		if dir := filepath.Dir(path); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		states[path] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	for _, inc := range w.cfg.Includes() {
+		for _, glob := range inc.globs {
+			if dir := filepath.Dir(glob); !seen[dir] && !hasMeta(dir) {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	return states, dirs
+}
+
+func (w *Watcher) changed(old, new map[string]fileState) bool {
+	if len(old) != len(new) {
+		return true
+	}
+
+	for path, st := range new {
+		if o, ok := old[path]; !ok || o != st {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasMeta(path string) bool {
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
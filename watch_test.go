@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherPolling(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.conf":        "name a;\ninclude b.conf;\ninclude conf.d/*.conf;\n",
+		"b.conf":        "zone b;\n",
+		"conf.d/c.conf": "zone c;\n",
+	})
+
+	cfg := New(filepath.Join(dir, "a.conf"))
+	v := &includeConf{}
+	wantError(t, cfg.Unmarshal(v), "")
+
+	changes := make(chan *includeConf, 16)
+	errs := make(chan error, 16)
+	w := cfg.Watch(v)
+	w.Polling = true
+	w.Interval = 10 * time.Millisecond
+	w.Debounce = 20 * time.Millisecond
+	w.OnChange = func(old, new interface{}) { changes <- new.(*includeConf) }
+	w.OnError = func(err error) { errs <- err }
+	wantError(t, w.Start(), "")
+	defer w.Close()
+
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// the size may not change, the time must
+		later := time.Now().Add(time.Duration(len(content)) * time.Second)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func() *includeConf {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case err := <-errs:
+			t.Fatalf("reload failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+		return nil
+	}
+
+	write("a.conf", "name root;\ninclude b.conf;\ninclude conf.d/*.conf;\n")
+	if c := next(); c.Name != "root" || len(c.Zone) != 2 {
+		t.Fatalf("root file: got %+v", *c)
+	}
+
+	write("b.conf", "zone included;\n")
+	if c := next(); len(c.Zone) != 2 || c.Zone[0] != "included" {
+		t.Fatalf("included file: got %+v", *c)
+	}
+
+	write("conf.d/d.conf", "zone d;\n")
+	c := next()
+	if len(c.Zone) != 3 || c.Zone[2] != "d" {
+		t.Fatalf("new file of a glob: got %+v", *c)
+	}
+	if w.Value() != c {
+		t.Fatal("Value is not the last reloaded value")
+	}
+}
+
+func TestWatcherReloadTarget(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\n"})
+
+	cfg := New(filepath.Join(dir, "a.conf"))
+	v := &includeConf{}
+	wantError(t, cfg.Unmarshal(v), "")
+
+	changes := make(chan *includeConf, 16)
+	w := cfg.Watch(v)
+	w.Polling = true
+	w.Interval = 10 * time.Millisecond
+	w.Debounce = 20 * time.Millisecond
+	w.OnChange = func(old, new interface{}) { changes <- new.(*includeConf) }
+	wantError(t, w.Start(), "")
+	defer w.Close()
+
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(filepath.Join(dir, "a.conf"), []byte("name b;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a.conf"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	var c *includeConf
+	select {
+	case c = <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload")
+	}
+
+	// Reload decodes into the value of Unmarshal, not into the reloaded one
+	if err := os.WriteFile(filepath.Join(dir, "a.conf"), []byte("name c;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	wantError(t, cfg.Reload(), "")
+	if c.Name != "b" || v.Name != "c" {
+		t.Fatalf("Reload decoded into the value of the watcher: %q, Unmarshal value %q", c.Name, v.Name)
+	}
+}
+
+func TestWatcherError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.conf": "name a;\n"})
+
+	cfg := New(filepath.Join(dir, "a.conf"))
+	v := &includeConf{}
+	wantError(t, cfg.Unmarshal(v), "")
+
+	errs := make(chan error, 16)
+	w := cfg.Watch(v)
+	w.Polling = true
+	w.Interval = 10 * time.Millisecond
+	w.Debounce = 20 * time.Millisecond
+	w.OnError = func(err error) { errs <- err }
+	wantError(t, w.Start(), "")
+	defer w.Close()
+
+	if err := os.WriteFile(filepath.Join(dir, "a.conf"), []byte("name a; bad;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		wantError(t, err, "unknown directive")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload")
+	}
+	if w.Value() != v {
+		t.Fatal("the value changed after a failed reload")
+	}
+}